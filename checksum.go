@@ -0,0 +1,124 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+//
+// This file implements the FITS checksum convention (CHECKSUM and DATASUM keys) as described in
+//  Seaman R., Pence W., Rots A. FITS Checksum Proposal (2002)
+//  http://fits.gsfc.nasa.gov/registry/checksum.html
+
+package fits
+
+import "strconv"
+
+// checksum adds the 32-bit 1's complement checksum of buf to sum and returns the result
+// buf is interpreted as a sequence of big-endian 32-bit integers, so its length should be a multiple of 4
+// (this is always true for FITS blocks)
+func checksum(sum uint32, buf []byte) uint32 {
+	hi := uint64(sum >> 16)
+	lo := uint64(sum & 0xffff)
+	for i := 0; i+3 < len(buf); i += 4 {
+		hi += uint64(buf[i])<<8 | uint64(buf[i+1])
+		lo += uint64(buf[i+2])<<8 | uint64(buf[i+3])
+	}
+	// folds the carries back into the 16-bit halves (end-around carry)
+	for hi>>16 != 0 || lo>>16 != 0 {
+		hicarry := hi >> 16
+		locarry := lo >> 16
+		hi = (hi & 0xffff) + locarry
+		lo = (lo & 0xffff) + hicarry
+	}
+	return uint32(hi<<16 | lo)
+}
+
+// encodeChecksum encodes the complement of sum as a 16-character ASCII string as required for the value of CHECKSUM
+// The encoding only uses alphanumeric characters and is designed such that replacing the initial value of CHECKSUM
+// ('0000000000000000') with the result makes the checksum of the whole HDU equal to -0 (all ones)
+func encodeChecksum(sum uint32) string {
+	const offset = 0x30 // ASCII '0'
+	var asc [16]byte
+
+	value := ^sum
+	for i := 0; i < 4; i++ {
+		b := int((value >> uint(24-8*i)) & 0xff)
+		quotient := b/4 + offset
+		remainder := b % 4
+		ch := [4]int{quotient + remainder, quotient, quotient, quotient}
+
+		// the punctuation characters between the digits and the letters are excluded by moving
+		// one unit between the pairs of characters, which does not change the sum
+		for check := true; check; {
+			check = false
+			for j := 0; j < 4; j += 2 {
+				for _, k := range [2]int{j, j + 1} {
+					if (ch[k] >= 0x3a && ch[k] <= 0x40) || (ch[k] >= 0x5b && ch[k] <= 0x60) {
+						ch[j]++
+						ch[j+1]--
+						check = true
+						break
+					}
+				}
+			}
+		}
+
+		for j := 0; j < 4; j++ {
+			asc[4*j+i] = byte(ch[j])
+		}
+	}
+
+	// the result is rotated right by one byte because the value of CHECKSUM starts in column 12 of its card
+	var s [16]byte
+	for i := 0; i < 16; i++ {
+		s[i] = asc[(i+15)%16]
+	}
+	return string(s[:])
+}
+
+// UpdateChecksum computes DATASUM and CHECKSUM for h and adds them to the header (or updates their values)
+// DATASUM is the checksum of the data section and CHECKSUM is set such that the checksum of the whole HDU (header and data) is -0
+// UpdateChecksum should be called after all other changes to Keys and Data are done, right before Write
+func (h *Unit) UpdateChecksum() error {
+	datasum := checksum(0, h.paddedData())
+	h.Keys["DATASUM"] = strconv.FormatUint(uint64(datasum), 10)
+	h.Keys["CHECKSUM"] = "0000000000000000" // CHECKSUM is initialized to all '0' and is part of the checksum calculation
+
+	header, err := h.encodeHeader()
+	if err != nil {
+		return err
+	}
+	h.Keys["CHECKSUM"] = encodeChecksum(checksum(datasum, header))
+
+	h.raw, err = h.encodeHeader()
+	return err
+}
+
+// ChecksumStatus is the result of VerifyChecksum
+// DATASUM and CHECKSUM are verified independently, since many files carry only one of them
+type ChecksumStatus struct {
+	HadDataSum  bool // DATASUM is present in the header
+	DataSumOK   bool // DATASUM is present and matches the checksum of the data
+	HadChecksum bool // CHECKSUM is present in the header
+	ChecksumOK  bool // CHECKSUM is present and the checksum of the whole HDU is -0
+}
+
+// VerifyChecksum verifies the values of DATASUM and CHECKSUM keys in the header, whichever is present
+// DATASUM is compared with the checksum of the data section and CHECKSUM is verified by checking that the checksum of
+// the whole HDU, computed based on the header as read from the file, is equal to -0. For units not read from a file
+// (e.g. created by NewBinTable or Clone), the header is encoded as by Write
+func (h *Unit) VerifyChecksum() (status ChecksumStatus) {
+	datasum := checksum(0, h.paddedData())
+
+	if s, ok := h.Keys["DATASUM"].(string); ok {
+		status.HadDataSum = true
+		n, err := strconv.ParseUint(s, 10, 32)
+		status.DataSumOK = err == nil && uint32(n) == datasum
+	}
+
+	if _, ok := h.Keys["CHECKSUM"].(string); ok {
+		status.HadChecksum = true
+		raw := h.raw
+		if len(raw) == 0 {
+			raw, _ = h.encodeHeader()
+		}
+		status.ChecksumOK = checksum(datasum, raw) == 0xffffffff
+	}
+	return status
+}
@@ -15,8 +15,6 @@ package main
 import (
 	"bytes"	
 	"fmt"
-	"image"
-	"image/color"
 	"image/png"
 	"io/ioutil"
 	"log"
@@ -72,10 +70,10 @@ func main() {
 	}
 
 	for i, h := range units { // for each HDU, extract the content
-		fmt.Printf("******************** Header %d ********************\n", i)
+		fmt.Printf("******************** Header %d: %s ********************\n", i, h.Summary())
 
-		for key, value := range h.Keys { // First, write all key/value pairs
-			fmt.Println(key, value)
+		for _, key := range h.SortedKeys() { // First, write all key/value pairs
+			fmt.Println(key, h.Keys[key])
 		}
 
 		out := fmt.Sprintf("%s_%d", name, i)
@@ -118,39 +116,29 @@ func writeArray(h *fits.Unit, name string) {
 //
 func writeImage(h *fits.Unit, name string) {
 	n := len(h.Naxis)
-	maxis := make([]int, n)
-	img := image.NewGray16(image.Rect(0, 0, h.Naxis[0], h.Naxis[1]))
+	plane := make([]int, n-2)
 	prod := 1
 	for k := 2; k < n; k++ {
 		prod *= h.Naxis[k]
 	}
-	min, max := h.Stats()
 
 	for i := 0; i < prod; i++ {
 		l := i
 		s := name
 		for k := 2; k < n; k++ {
-			maxis[k] = l % h.Naxis[k]
+			plane[k-2] = l % h.Naxis[k]
 			l = l / h.Naxis[k]
-			s += fmt.Sprintf("-%d", maxis[k])
+			s += fmt.Sprintf("-%d", plane[k-2])
 		}
 
-		for x := 0; x < h.Naxis[0]; x++ {
-			for y := 0; y < h.Naxis[1]; y++ {
-				maxis[0] = x
-				maxis[1] = y
-				if !h.Blank(maxis...) {
-					v := uint16((h.FloatAt(maxis...) - min) / (max - min) * 65535) // normalizes based on min and max in the whole image cube
-					img.SetGray16(x, h.Naxis[1]-y, color.Gray16{v})
-				} else {
-					img.SetGray16(x, h.Naxis[1]-y, color.Gray16{0}) // blank pixel
-				}
-			}
+		img, err := h.ToImage(fits.Linear, plane) // normalizes based on min and max in the whole image cube
+		if err != nil {
+			log.Println(err)
+			return
 		}
-
 		g, _ := os.Create(s + ".png")
-		defer g.Close()
 		png.Encode(g, img)
+		g.Close()
 	}
 }
 
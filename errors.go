@@ -0,0 +1,54 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import "fmt"
+
+// The following error types are returned by Open (and the functions called by it) to describe why a file could not be processed
+// They can be distinguished by a type switch or errors.As, e.g.
+//
+//      var missing fits.ErrMissingKeyword
+//      if errors.As(err, &missing) {
+//          fmt.Println("missing key:", missing.Key)
+//      }
+//
+
+// ErrMissingKeyword is returned when a mandatory key (e.g. BITPIX or NAXISn) is missing from a header
+type ErrMissingKeyword struct {
+	Key string
+}
+
+func (e ErrMissingKeyword) Error() string {
+	return fmt.Sprintf("No %v in the header", e.Key)
+}
+
+// ErrBadValue is returned when the value of a key is not valid (e.g. BITPIX=12); Raw is the value as found in the header
+type ErrBadValue struct {
+	Key string
+	Raw string
+}
+
+func (e ErrBadValue) Error() string {
+	return fmt.Sprintf("Invalid value of %v: %v", e.Key, e.Raw)
+}
+
+// ErrUnsupportedForm is returned when TFORMn of a table field is not valid or is not supported
+type ErrUnsupportedForm struct {
+	Form string
+}
+
+func (e ErrUnsupportedForm) Error() string {
+	return fmt.Sprintf("Unsupported or invalid TFORM: %q", e.Form)
+}
+
+// ErrTruncated is returned when the file ends before the end of a data section
+// Size is the expected size of the data section in bytes and Read is the number of bytes actually read
+type ErrTruncated struct {
+	Size int
+	Read int
+}
+
+func (e ErrTruncated) Error() string {
+	return fmt.Sprintf("Data is truncated (read %d of %d bytes)", e.Read, e.Size)
+}
@@ -13,14 +13,15 @@
 // The following features are supported in the current version:
 //      1. Images with all six different data format (byte, int16, int32, int64, float32, and float64)
 //      2. Text and binary tables with atomic and fixed-size array elements
+//      3. Variable length arrays in binary tables (see VarArray)
+//      4. The physical values of images based on BSCALE/BZERO (see ScaledAt); Data always holds the stored values
+//      5. World coordinate system, with the gnomonic (TAN) projection for celestial axes (see WCS and PixToWorld)
 //
 // The following features are not yet implemented:
-//      1. Automatic application of BSCALE/BZERO
-//      2. Random group structure
-//      3. Variable length arrays in binary tables
-//      4. World coordinate system
+//      1. Random group structure
+//      2. Other celestial projections (e.g. SIN or ZEA)
 //
-// Also note that currently this package provides only read capability and does not write/generate a FITS file.
+// Also note that the write capability is currently limited to Write, which writes a list of units as a FITS file.
 //
 // The basic usage of the package is by calling Open function. It accepts a reader that should provide a valid FITS file.
 // The output is a []*fits.Unit, where Unit represents a Header/Data Unit (i.e. a header with the corresponding data).
@@ -75,12 +76,17 @@ package fits
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
+	"path"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"unicode"
 )
 
 // FieldFunc are the type of accessor functions returned by Unit.Field() 
@@ -106,6 +112,7 @@ type Unit struct {
 	Data   interface{}
 	list   []FieldFunc          // A slice to help with access to FieldFunc based on index
 	fields map[string]FieldFunc // A map of FieldFunc (field-name => accessor-function)
+	names  map[string]int       // A map of the field indices (field-name => 1-based index)
 	// field-name is based on TTYPE{k} keys in the header
 	class string                     // class holds the type of the Header (SIMPLE, IMAGE, TABLE and BINTABLE)
 	blank int                        // The value of BLANK key in the header 
@@ -116,6 +123,42 @@ type Unit struct {
 	FloatAt func(a ...int) float64 // A helper accessor function that returns the pixel value as float64
 	Blank   func(a ...int) bool    // returns true if pixel type is integral and the pixel pointed by a... is equal to blank,
 	// or the pixel type is float and its value is NaN                                             
+	BlankCards []string               // The content (columns 9-80) of the cards with a blank keyword in the order they appear in the header
+	History    []string               // The text (columns 9-80) of the HISTORY cards in the order they appear in the header
+	Comments   []string               // The text (columns 9-80) of the COMMENT cards in the order they appear in the header
+	Warnings   []Warning              // Non-fatal problems found while reading the header (see Warning)
+	columns    []column               // The layout of the table fields, columns[k] describes the k'th field
+	raw        []byte                 // The header blocks as read from the file (or as generated by UpdateChecksum), used by VerifyChecksum
+	order      []string               // The keys in the order they first appear in the header (see OrderedCards)
+	defaults   map[string]interface{} // The keys added to Keys by buildTable (TTYPEn and TDISPn), which are not written
+	tokens     map[string]string      // The original text of the NaN and Inf values of the keys (see specialFloat), used by Write; it is not modified after reading and is shared by the copies of the unit
+	heap       []byte                 // The PCOUNT bytes following the main table of a binary table, holding the variable length arrays (see VarArray)
+	dups       []string               // The keys that appear more than once in the header, excluding the legal repeaters (see Duplicates)
+	parent     *Unit                  // The primary HDU, set for extensions with INHERIT=T if the Inherit option is enabled (see Lookup)
+	statsOnce  sync.Once              // Guards the calculation of the values cached by Stats
+	statsMin   float64                // The minimum value returned by Stats
+	statsMax   float64                // The maximum value returned by Stats
+}
+
+// column describes the location of a table field in each row (record) of the table data
+type column struct {
+	code   byte         // The type code from TFORM (e.g. 'E' or 'J')
+	repeat int          // The repeat count for binary tables or the field width for text tables
+	offset int          // The byte index of the field from the beginning of each row
+	elem   byte         // The type code of the elements of a variable length array field (e.g. 'E' for TFORM=1PE), 0 for other fields
+	descs  []Descriptor // The array descriptors of a variable length array field for each row, used to locate the arrays in the heap
+}
+
+// Descriptor is the value stored in the cells of variable length array fields (TFORM=rPt or rQt) of binary tables
+// Count is the number of elements in the array and Offset is the byte offset of the first element from the start of the heap
+type Descriptor struct {
+	Count  int64
+	Offset int64
+}
+
+// String formats d as (count,offset)
+func (d Descriptor) String() string {
+	return fmt.Sprintf("(%d,%d)", d.Count, d.Offset)
 }
 
 // Reader is a buffered Reader implementation that works based on the FITS block structure (each 2880 bytes long)
@@ -126,13 +169,15 @@ type Reader struct {
 	right  int
 	reader io.Reader
 	eof    bool
+	opts   Options // The options passed to OpenWith
+	mapped []byte  // The memory-mapped file read by OpenMmap (reader is a bytes.Reader over it), nil otherwise
 }
 
 // Field returns a FieldFunc corresponding to col
 // If col is int, the col'th field is returned (note: col is 0 based, so col=1 means TFORM2)
-// If col a string, the field with TDISP equal to col is returned
+// If col a string, the field with TDISP equal to col is returned (the first one if more than one field has this name, see FieldN)
 // Fields are held in a map (Unit.fields) based on their name (TDISP). 
-// In addition, for each field, an entry with key "#name" is added to Unit.fields to facilitate the search for TDISP based on the name
+// In addition, the index of each field is held in a separate map (Unit.names) to facilitate the search for TDISP based on the name
 //
 // Note: this function returns an accessor function, that needs to be called to obtain the actual cell value
 // For example, assume h is a table. One of its column is named "ID" of type "J" (int32)
@@ -162,6 +207,117 @@ func (h *Unit) Field(col interface{}) FieldFunc {
 	}
 }
 
+// FieldN returns a FieldFunc corresponding to the occurrence'th field named name (occurrence is 0 based)
+// The standard permits more than one field with the same TTYPE, in which case Field(name) returns the first one
+// If there is no such field, the returned FieldFunc returns nil, similar to Field
+func (h *Unit) FieldN(name string, occurrence int) FieldFunc {
+	for i, s := range h.FieldNames() {
+		if s == name {
+			if occurrence == 0 {
+				return h.Field(i)
+			}
+			occurrence--
+		}
+	}
+	return func(int) interface{} {
+		return nil
+	}
+}
+
+// FieldNames returns the names of the fields of a table in order, based on TTYPEn
+// Fields without a TTYPE are named COLn (n is 1-based), which is the same default used by Field
+func (h *Unit) FieldNames() []string {
+	names := make([]string, len(h.columns))
+	for i := range names {
+		name, ok := h.Keys[Nth("TTYPE", i+1)].(string)
+		if !ok {
+			name = Nth("COL", i+1)
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// Rows returns an iterator over the rows of a table. Each call to the iterator returns the next row as a map
+// from the field names (see FieldNames) to the cell values (as returned by Field), and false after the last row, e.g.
+//
+//      next := h.Rows()
+//      for row, ok := next(); ok; row, ok = next() {
+//          fmt.Println(row["FLUX"])
+//      }
+//
+func (h *Unit) Rows() func() (map[string]interface{}, bool) {
+	names := h.FieldNames()
+	row := 0
+	return func() (map[string]interface{}, bool) {
+		if !h.HasTable() || row >= h.Naxis[1] {
+			return nil, false
+		}
+		m := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			if fn := h.list[i]; fn != nil {
+				m[name] = fn(row)
+			} else {
+				m[name] = nil // a field with a repeat count of 0
+			}
+		}
+		row++
+		return m, true
+	}
+}
+
+// FieldRaw returns an accessor function that gives the raw bytes of the cells of col (defined as in Field) as stored in the table
+// It works for any field, including the types that are not decoded by Field (e.g. packed bits), and can be used to write custom decoders
+// The returned slice shares memory with Data and should not be modified. The accessor function returns nil for out-of-range rows
+// FieldRaw returns nil if col is not found
+func (h *Unit) FieldRaw(col interface{}) func(row int) []byte {
+	n := h.fieldIndex(col)
+	if n == -1 {
+		return nil
+	}
+	c := h.columns[n]
+	w := c.width(h.class != "TABLE")
+	data := h.Data.([]byte)
+	return func(row int) []byte {
+		if row < 0 || row >= h.Naxis[1] {
+			return nil
+		}
+		start := row*h.Naxis[0] + c.offset
+		return data[start : start+w : start+w]
+	}
+}
+
+// FieldTrimmed is similar to Field, but the accessor function trims the string values (fields with TFORM=rA)
+// String fields are padded to their fixed width, usually with spaces. Some writers terminate the strings with a NUL byte instead,
+// which the standard also allows; the characters after the first NUL are undefined and are discarded
+// Therefore, each string is cut at the first NUL (if any) and then the trailing spaces are removed. The leading spaces are significant and are kept
+// The values of other types are returned unchanged. Field returns the raw padded strings
+func (h *Unit) FieldTrimmed(col interface{}) FieldFunc {
+	fn := h.Field(col)
+	return func(row int) interface{} {
+		switch x := fn(row).(type) {
+		case string:
+			return trimString(x)
+		case []string:
+			p := make([]string, len(x))
+			for i, s := range x {
+				p[i] = trimString(s)
+			}
+			return p
+		default:
+			return x
+		}
+	}
+}
+
+// trimString removes the padding of a string field value (see FieldTrimmed)
+func trimString(s string) string {
+	if i := strings.IndexByte(s, 0); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimRight(s, " ")
+}
+
 // Format returns a formatted string based on the given col and row and TDISP of the col
 // col can be an int or a string (same as Field)
 // The return value is a string, which is obtained by 
@@ -169,43 +325,53 @@ func (h *Unit) Field(col interface{}) FieldFunc {
 //      2. Running the FieldFunc by passing row as an argument 
 //      3. Applying format to the result 
 //
+// If the field is declared as a multi-dimensional array by TDIM, the elements are grouped based on the TDIM shape
+// For example, a cell with TDIM equal to (3,3) is formatted as [[a b c] [d e f] [g h i]]
+//
 func (h *Unit) Format(col interface{}, row int) string {
 	var fn FieldFunc
 	var disp interface{}
+	var k int // 1-based index of the field
 
 	switch col.(type) {
 	case int:
 		n := col.(int)
 		if n >= 0 && n < len(h.list) {
 			fn = h.list[col.(int)]
-			disp, _ = h.Keys[Nth("TDISP", n+1)]
+			k = n + 1
+			disp, _ = h.Keys[Nth("TDISP", k)]
 		}
 	case string:
 		name := col.(string)
 		fn, _ = h.fields[name]
-		n := h.Keys["#"+name]
-		disp, _ = h.Keys[Nth("TDISP", n.(int))]
+		k = h.names[name]
+		disp, _ = h.Keys[Nth("TDISP", k)]
 	}
 
 	if fn == nil {
 		return ""
 	}
 
-	format := "%v" // default format
+	format := "%v"   // default format
+	var variant byte // 'N' for ENw.d and 'S' for ESw.d
 
 	w := 14
+	m := -1
+	e := -1 // the number of exponent digits (Ew.dEe and Gw.dEe)
 	if disp != nil {
 		var code rune
-		m := -1
-		d := disp.(string)
+		d := strings.ToUpper(disp.(string)) // some writers use lowercase codes, e.g. f10.4 or es12.4e2
 
-		// accounts for ENw.d and ESw.d formats
-		if len(d) > 1 && (d[1] == 'N' || d[1] == 'S') {
+		// accounts for ENw.d (engineering) and ESw.d (scientific) formats
+		if len(d) > 1 && d[0] == 'E' && (d[1] == 'N' || d[1] == 'S') {
+			variant = d[1]
 			d = string(d[0]) + string(d[2:]) // removes the second character from the format string
-			// The standard allows to disregard this secondary format characters 
 		}
 
 		fmt.Sscanf(d, "%c%d.%d", &code, &w, &m)
+		if j := strings.IndexByte(d[1:], 'E'); j != -1 {
+			fmt.Sscanf(d[j+2:], "%d", &e)
+		}
 
 		switch code {
 		case 'A':
@@ -225,21 +391,181 @@ func (h *Unit) Format(col interface{}, row int) string {
 				format = fmt.Sprintf("%%%df", w) // Fw -> %wf
 			}
 		case 'E':
-			if m != -1 {
-				format = fmt.Sprintf("%%%d.%de", w, m) // Fw.d -> %w.df
+			if m == -1 {
+				m = 6
+			}
+			if variant == 'S' {
+				format = fmt.Sprintf("%%+%d.%dE", w, m) // ESw.d -> %+w.dE, the sign of the mantissa is always written
 			} else {
-				format = fmt.Sprintf("%%%de", w) // Ew -> %we
+				format = fmt.Sprintf("%%%d.%de", w, m) // Ew.d -> %w.de
 			}
 		case 'G':
 			if m != -1 {
-				format = fmt.Sprintf("%%%d.%dg", w, m) // Fw.d -> %w.df
+				format = fmt.Sprintf("%%%d.%dg", w, m) // Gw.d -> %w.dg
 			} else {
 				format = fmt.Sprintf("%%%dg", w) // Gw -> %wg
 			}
 		}
 	}
 
-	return fmt.Sprintf(format, fn(row))
+	conv := func(x interface{}) string {
+		return padExponent(fmt.Sprintf(format, x), e)
+	}
+	if variant == 'N' {
+		conv = func(x interface{}) string {
+			return formatEng(x, w, m)
+		}
+	}
+
+	x := fn(row)
+	v := reflect.ValueOf(x)
+	if dims := h.tdim(k); len(dims) > 1 {
+		if v.Kind() == reflect.Slice && v.Len() == product(dims) {
+			return formatDims(conv, v, dims)
+		}
+	}
+	if variant == 'N' && v.Kind() == reflect.Slice {
+		return formatDims(conv, v, []int{v.Len()})
+	}
+	return conv(x)
+}
+
+// padExponent pads the exponent of a number formatted by %e or %g (s) with zeros to e digits, as specified by the Ee suffix of
+// TDISP (e.g. G12.5E3 formats 1.5e+05 as 1.5e+005). Go always writes at least two exponent digits
+// The leading spaces of s are consumed, if possible, to keep the width unchanged
+// s is returned as is if e < 0 or s has no exponent (%g may choose the fixed notation)
+func padExponent(s string, e int) string {
+	j := strings.LastIndexAny(s, "eE")
+	if e < 0 || j == -1 || j+2 > len(s) {
+		return s
+	}
+	digits := s[j+2:]
+	if len(digits) >= e {
+		return s
+	}
+	pad := e - len(digits)
+	s = s[:j+2] + strings.Repeat("0", pad) + digits
+	n := len(s) - len(strings.TrimLeft(s, " "))
+	if n > pad {
+		n = pad
+	}
+	return s[n:]
+}
+
+// formatEng formats x (a number) in the engineering notation (TDISP=ENw.d) right-justified in w characters
+// The exponent is a multiple of three and the mantissa has d digits after the decimal point and is in [1, 1000), e.g. 12.345E+03
+func formatEng(x interface{}, w int, d int) string {
+	v := reflect.ValueOf(x)
+	var f float64
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f = v.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f = float64(v.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f = float64(v.Uint())
+	default:
+		return fmt.Sprintf("%*v", w, x)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprintf("%*v", w, f)
+	}
+
+	e := 0
+	if f != 0 {
+		e = int(math.Floor(math.Log10(math.Abs(f))/3)) * 3
+	}
+	mant := strconv.FormatFloat(f/math.Pow10(e), 'f', d, 64)
+	if x, _ := strconv.ParseFloat(mant, 64); math.Abs(x) >= 1000 { // rounding may carry the mantissa to the next power of 1000
+		e += 3
+		mant = strconv.FormatFloat(f/math.Pow10(e), 'f', d, 64)
+	}
+	return fmt.Sprintf("%*s", w, fmt.Sprintf("%sE%+03d", mant, e))
+}
+
+// formatDims is a helper function for Format that formats the elements of an array cell (v) by conv and groups them based on dims
+// dims follows the TDIM order, i.e. dims[0] is the fastest changing index
+func formatDims(conv func(x interface{}) string, v reflect.Value, dims []int) string {
+	n := len(dims) - 1
+	parts := make([]string, 0, dims[n])
+	if n == 0 {
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, conv(v.Index(i).Interface()))
+		}
+	} else {
+		step := product(dims[:n])
+		for i := 0; i < dims[n]; i++ {
+			parts = append(parts, formatDims(conv, v.Slice(i*step, (i+1)*step), dims[:n]))
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// tdim parses the TDIM key of the k'th field (k is 1-based) and returns the dimensions of the field cells
+// nil is returned if TDIM is missing or is not valid
+func (h *Unit) tdim(k int) []int {
+	s, ok := h.Keys[Nth("TDIM", k)].(string)
+	if !ok {
+		return nil
+	}
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil
+	}
+	items := strings.Split(s[1:len(s)-1], ",")
+	dims := make([]int, len(items))
+	for i, item := range items {
+		d, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || d <= 0 {
+			return nil
+		}
+		dims[i] = d
+	}
+	return dims
+}
+
+// product returns the product of the elements of a, e.g. the number of pixels given Naxis
+func product(a []int) int {
+	prod := 1
+	for _, x := range a {
+		prod *= x
+	}
+	return prod
+}
+
+// IsUndefined returns true if the cell pointed by col and row belongs to a logical field (TFORM=L) and holds an undefined value
+// col is defined as in Field. The accessor function of a logical field returns false for undefined values
+// The standard uses 0 as the undefined value, but an ASCII space, which is used by some writers, is also accepted
+// For fixed-size arrays, IsUndefined returns true if any of the elements is undefined
+func (h *Unit) IsUndefined(col interface{}, row int) bool {
+	n := h.fieldIndex(col)
+	if n == -1 || h.columns[n].code != 'L' || row < 0 || row >= h.Naxis[1] {
+		return false
+	}
+	data := h.Data.([]byte)
+	k := row*h.Naxis[0] + h.columns[n].offset
+	for _, x := range data[k : k+h.columns[n].repeat] {
+		if x == 0 || x == ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldIndex returns the 0-based index of the field defined by col (an int or a string, same as Field)
+// It returns -1 if no such field exists
+func (h *Unit) fieldIndex(col interface{}) int {
+	switch x := col.(type) {
+	case int:
+		if x >= 0 && x < len(h.columns) {
+			return x
+		}
+	case string:
+		if n, ok := h.names[x]; ok {
+			return n - 1
+		}
+	}
+	return -1
 }
 
 // HasImage returns true is the Unit is either SIMPLE or IMAGE and has the data for an actual image
@@ -252,17 +578,111 @@ func (h *Unit) HasTable() bool {
 	return (h.class == "TABLE" || h.class == "BINTABLE")
 }
 
+// Match returns the header keys matching pattern and their values
+// pattern is a glob pattern with the same syntax as path.Match, e.g. "CRVAL*" or "TTYPE?"
+// The result is an empty map if nothing matches or pattern is malformed
+func (h *Unit) Match(pattern string) map[string]interface{} {
+	p := make(map[string]interface{})
+	for key, value := range h.Keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			p[key] = value
+		}
+	}
+	return p
+}
+
+// RenameKey renames the key old to new, keeping its value and its position in the header (see OrderedCards), e.g. to fix
+// a misspelled key or to migrate a deprecated one. new should be a valid keyword (see validKeyword)
+// An error is returned if old is missing, new already exists or new is not valid
+func (h *Unit) RenameKey(old, new string) error {
+	value, ok := h.Keys[old]
+	if !ok {
+		return ErrMissingKeyword{old}
+	}
+	if _, exists := h.Keys[new]; exists {
+		return fmt.Errorf("Keyword %v already exists", new)
+	}
+	if !validKeyword(new) {
+		return fmt.Errorf("Invalid keyword: %q", new)
+	}
+	delete(h.Keys, old)
+	h.Keys[new] = value
+	h.order = append([]string(nil), h.order...) // order and dups may be shared with the unit h is derived from
+	h.dups = append([]string(nil), h.dups...)
+	for i, key := range h.order {
+		if key == old {
+			h.order[i] = new
+		}
+	}
+	for i, key := range h.dups {
+		if key == old {
+			h.dups[i] = new
+		}
+	}
+	return nil
+}
+
+// validKeyword returns true if key is a valid keyword: up to 8 characters, each an uppercase letter, a digit, '-' or '_',
+// or a HIERARCH key as stored by NewHeader (e.g. "HIERARCH ESO DET CHIP ID") that fits in a card
+func validKeyword(key string) bool {
+	if strings.HasPrefix(key, "HIERARCH ") {
+		return len(key) < 77 && strings.Join(strings.Fields(key), " ") == key && !strings.Contains(key, "=")
+	}
+	if key == "" || len(key) > 8 {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the value of key in the header of h
+// If key is missing and h inherits the keys of the primary HDU (an extension with INHERIT=T opened with the Inherit option),
+// the value is looked up in the primary header. The extension wins if the key is present in both
+// The keys that describe the primary HDU itself (SIMPLE, EXTEND, CHECKSUM, DATASUM, COMMENT and HISTORY) are not inherited
+func (h *Unit) Lookup(key string) (interface{}, bool) {
+	if value, ok := h.Keys[key]; ok {
+		return value, true
+	}
+	if h.parent == nil {
+		return nil, false
+	}
+	switch key {
+	case "SIMPLE", "EXTEND", "CHECKSUM", "DATASUM", "COMMENT", "HISTORY":
+		return nil, false
+	}
+	value, ok := h.parent.Keys[key]
+	return value, ok
+}
+
 // Bitpix is a helper function the simply returns BITPIX value in the header
 func (h *Unit) Bitpix() int {
 	return h.Keys["BITPIX"].(int)
 }
 
 // Stats returns the minimum and maximum values in the image data
+// The result is computed on the first call and is cached; the following calls return the cached values
+// Call InvalidateStats after modifying Data to force a recalculation. Stats is safe for concurrent use
 func (h *Unit) Stats() (min float64, max float64) {
-	prod := 1
-	for _, x := range h.Naxis {
-		prod *= x
-	}
+	h.statsOnce.Do(func() {
+		h.statsMin, h.statsMax = h.stats()
+	})
+	return h.statsMin, h.statsMax
+}
+
+// InvalidateStats discards the values cached by Stats, so the next call to Stats scans the image data again
+// It should be called after Data is modified and should not be called concurrently with Stats
+func (h *Unit) InvalidateStats() {
+	h.statsOnce = sync.Once{}
+}
+
+// stats scans the image data and calculates the values returned by Stats
+// Blank pixels (integral types) and NaN pixels (float types) are excluded
+func (h *Unit) stats() (min float64, max float64) {
+	prod := product(h.Naxis)
 	if prod == 1 {
 		return
 	}
@@ -270,79 +690,302 @@ func (h *Unit) Stats() (min float64, max float64) {
 	min = math.MaxFloat64
 	max = -math.MaxFloat64
 
-	switch h.Bitpix() {
-	case 8:
-		for i := 0; i < prod; i++ {
-			x := int(h.Data.([]byte)[i])
-			if x != h.blank && float64(x) < min {
-				min = float64(x)
-			}
-			if x != h.blank && float64(x) > max {
-				max = float64(x)
-			}
+	value := h.flatValue()
+	for i := 0; i < prod; i++ {
+		x, ok := value(i)
+		if ok && x < min {
+			min = x
 		}
-	case 16:
-		for i := 0; i < prod; i++ {
-			x := int(h.Data.([]int16)[i])
-			if x != h.blank && float64(x) < min {
-				min = float64(x)
-			}
-			if x != h.blank && float64(x) > max {
-				max = float64(x)
-			}
+		if ok && x > max {
+			max = x
 		}
-	case 32:
-		for i := 0; i < prod; i++ {
-			x := int(h.Data.([]int32)[i])
-			if x != h.blank && float64(x) < min {
-				min = float64(x)
-			}
-			if x != h.blank && float64(x) > max {
-				max = float64(x)
-			}
+	}
+	return
+}
+
+// StatsLoc is similar to Stats, but also returns the coordinates of the minimum and maximum pixels (in the same order as the arguments of At)
+// The image is scanned once and blank pixels (integral types) and NaN pixels (float types) are excluded
+// If there are ties, the first pixel in the Data order is reported. If there are no valid pixels, min and max are NaN and minAt and maxAt are nil
+func (h *Unit) StatsLoc() (min float64, minAt []int, max float64, maxAt []int) {
+	value := h.flatValue()
+	imin, imax := -1, -1
+	for i, n := 0, product(h.Naxis); i < n; i++ {
+		x, ok := value(i)
+		if !ok {
+			continue
 		}
-	case 64:
-		for i := 0; i < prod; i++ {
-			x := int(h.Data.([]int64)[i])
-			if x != h.blank && float64(x) < min {
-				min = float64(x)
-			}
-			if x != h.blank && float64(x) > max {
-				max = float64(x)
-			}
+		if imin == -1 || x < min {
+			min, imin = x, i
 		}
-	case -32:
-		for i := 0; i < prod; i++ {
-			x := float64(h.Data.([]float32)[i])
-			if !math.IsNaN(x) && x < min {
-				min = x
-			}
-			if !math.IsNaN(x) && x > max {
-				max = x
-			}
+		if imax == -1 || x > max {
+			max, imax = x, i
 		}
-	case -64:
-		for i := 0; i < prod; i++ {
-			x := h.Data.([]float64)[i]
-			if !math.IsNaN(x) && x < min {
-				min = x
-			}
-			if !math.IsNaN(x) && x > max {
-				max = x
-			}
+	}
+	if imin == -1 {
+		return math.NaN(), nil, math.NaN(), nil
+	}
+	return min, h.coords(imin), max, h.coords(imax)
+}
+
+// flatValue returns a function that gives the value of the i'th pixel in the flat Data array as float64
+// ok is false if the pixel is blank (equal to BLANK for integral types or NaN for float types)
+func (h *Unit) flatValue() func(i int) (x float64, ok bool) {
+	blank, hasBlank := h.blankValue()
+	isBlank := func(x int64) bool {
+		return hasBlank && x == int64(blank)
+	}
+	switch data := h.Data.(type) {
+	case []byte:
+		return func(i int) (float64, bool) {
+			return float64(data[i]), !isBlank(int64(data[i]))
+		}
+	case []int16:
+		return func(i int) (float64, bool) {
+			return float64(data[i]), !isBlank(int64(data[i]))
+		}
+	case []int32:
+		return func(i int) (float64, bool) {
+			return float64(data[i]), !isBlank(int64(data[i]))
+		}
+	case []int64:
+		return func(i int) (float64, bool) {
+			return float64(data[i]), !isBlank(data[i])
+		}
+	case []float32:
+		return func(i int) (float64, bool) {
+			return float64(data[i]), !math.IsNaN(float64(data[i]))
 		}
+	case []float64:
+		return func(i int) (float64, bool) {
+			return data[i], !math.IsNaN(data[i])
+		}
+	}
+	return func(i int) (float64, bool) {
+		return 0, false
+	}
+}
+
+// coords is the inverse of index: it returns the coordinates of the i'th pixel in the flat Data array
+func (h *Unit) coords(i int) []int {
+	a := make([]int, len(h.Naxis))
+	for k, n := range h.Naxis {
+		a[k] = i % n
+		i /= n
+	}
+	return a
+}
+
+// StatsFast is similar to Stats but uses the DATAMIN and DATAMAX keys, if both are present in the header, instead of scanning the image data
+// DATAMIN and DATAMAX hold physical values; they are converted back to the stored values using BSCALE and BZERO to match the output of Stats
+// If either key is missing or the recorded range is not valid, StatsFast falls back to Stats
+func (h *Unit) StatsFast() (min float64, max float64) {
+	lo, ok1 := h.floatKey("DATAMIN")
+	hi, ok2 := h.floatKey("DATAMAX")
+	if !ok1 || !ok2 || math.IsNaN(lo) || math.IsNaN(hi) || lo > hi {
+		return h.Stats()
+	}
+	bscale, bzero := h.Scaling()
+	if bscale == 0 {
+		return h.Stats()
+	}
+	min = (lo - bzero) / bscale
+	max = (hi - bzero) / bscale
+	if min > max { // a negative BSCALE reverses the order
+		min, max = max, min
+	}
+	return
+}
+
+// Scaling returns the values of BSCALE and BZERO keys in the header
+// The default values (1 and 0) are returned for the missing keys
+// The physical value of a pixel is equal to bzero + bscale * stored-value
+func (h *Unit) Scaling() (bscale float64, bzero float64) {
+	bscale, ok := h.floatKey("BSCALE")
+	if !ok {
+		bscale = 1
 	}
+	bzero, _ = h.floatKey("BZERO")
 	return
 }
 
+// ScaledAt returns the physical value of the pixel pointed by a..., i.e. BZERO + BSCALE * FloatAt(a...)
+// The result is in the units given by BUNIT
+// Note that the scaling is never applied to Data in place: Data always holds the stored (raw) values as read from the file,
+// so a unit can be written back unchanged, and the physical values are only available through ScaledAt and ScaledFloat32
+// For this reason, there is no in-place scaling mode: the raw values are always kept, as if Options.KeepRaw is set
+func (h *Unit) ScaledAt(a ...int) float64 {
+	bscale, bzero := h.Scaling()
+	return bzero + bscale*h.FloatAt(a...)
+}
+
+// ScaledFloat32 is similar to ScaledAt, but returns the physical value as float32, which is enough for images with BITPIX=8 or 16
+// It is convenient for callers that collect the scaled values in a []float32; the values are computed on each call and
+// nothing is cached, so Data (the raw values) is still held in memory as usual
+func (h *Unit) ScaledFloat32(a ...int) float32 {
+	return float32(h.ScaledAt(a...))
+}
+
+// BUnit returns the value of BUNIT key, which describes the physical units of the scaled pixel values (e.g. "Jy/beam" or "K")
+// It returns "" if BUNIT is missing
+func (h *Unit) BUnit() string {
+	s, _ := h.Keys["BUNIT"].(string)
+	return s
+}
+
+// Describe returns a short description of the image data in h including BITPIX, dimensions, BUNIT and scaling
+// For example: "BITPIX=16 NAXIS=512x256 BUNIT=Jy/beam BSCALE=0.5 BZERO=32768"
+func (h *Unit) Describe() string {
+	bitpix, _ := h.Keys["BITPIX"].(int)
+	s := fmt.Sprintf("BITPIX=%d NAXIS=%s", bitpix, dimString(h.Naxis))
+	if bunit := h.BUnit(); bunit != "" {
+		s += " BUNIT=" + bunit
+	}
+	bscale, bzero := h.Scaling()
+	return s + fmt.Sprintf(" BSCALE=%g BZERO=%g", bscale, bzero)
+}
+
+// Summary returns a one-line description of h, e.g. "IMAGE 512x256 float32" or "BINTABLE 10 cols x 5 rows"
+// EXTNAME is appended in brackets if present. Summary returns "unknown HDU" if h is not a valid image or table
+func (h *Unit) Summary() string {
+	var s string
+	switch {
+	case h.HasTable() && len(h.Naxis) == 2:
+		tfields, _ := h.Keys["TFIELDS"].(int)
+		s = fmt.Sprintf("%s %d cols x %d rows", h.class, tfields, h.Naxis[1])
+	case h.HasImage():
+		bitpix, _ := h.Keys["BITPIX"].(int)
+		t := typeName(bitpix)
+		if t == "" {
+			return "unknown HDU"
+		}
+		s = fmt.Sprintf("%s %s %s", h.class, dimString(h.Naxis), t)
+	case h.class == "SIMPLE" || h.class == "IMAGE":
+		s = h.class + " (no data)"
+	default:
+		return "unknown HDU"
+	}
+	if name, ok := h.Keys["EXTNAME"].(string); ok {
+		s += " [" + name + "]"
+	}
+	return s
+}
+
+// typeName returns the name of the Go type used to store pixels based on bitpix (see Unit)
+// It returns "" for invalid values of bitpix
+func typeName(bitpix int) string {
+	switch bitpix {
+	case 8:
+		return "uint8"
+	case 16:
+		return "int16"
+	case 32:
+		return "int32"
+	case 64:
+		return "int64"
+	case -32:
+		return "float32"
+	case -64:
+		return "float64"
+	}
+	return ""
+}
+
+// ElementSize returns the size of each pixel in bytes based on bitpix, i.e. |bitpix|/8
+// It returns 0 for invalid values of bitpix (anything other than 8, 16, 32, 64, -32 and -64)
+func ElementSize(bitpix int) int {
+	if typeName(bitpix) == "" {
+		return 0
+	}
+	if bitpix < 0 {
+		bitpix = -bitpix
+	}
+	return bitpix / 8
+}
+
+// dimString formats the dimensions in naxis as a string like 512x256
+func dimString(naxis []int) string {
+	dims := make([]string, len(naxis))
+	for i, x := range naxis {
+		dims[i] = strconv.Itoa(x)
+	}
+	return strings.Join(dims, "x")
+}
+
+// floatKey returns the value of a numerical key as float64
+// NewHeader stores numbers without a decimal point as int, so both int and float64 values are accepted
+func (h *Unit) floatKey(key string) (float64, bool) {
+	switch x := h.Keys[key].(type) {
+	case int:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// Options modifies the behavior of OpenWith
+// The zero value results in the default behavior (same as Open)
+type Options struct {
+	// Resync enables a best-effort recovery mode for malformed files (e.g. FITS files concatenated or appended
+	// without proper padding): if a block that should start a header does not start with SIMPLE or XTENSION,
+	// it is scanned for a card that does, and the following blocks are skipped until one is found. A header starting
+	// at a card boundary (a multiple of 80 bytes) inside a block is recovered and the reading continues aligned to it;
+	// a header starting at any other offset is not found
+	Resync bool
+
+	// Inherit enables the INHERIT convention: for an extension with INHERIT=T, Lookup falls back to the keys of the primary HDU
+	// for the keys that are missing from the extension (see Lookup). Keys itself is not modified
+	Inherit bool
+
+	// WarnDuplicates adds a Warning for each keyword that is not allowed to repeat (e.g. BITPIX) but appears more than once in
+	// a header. Such keys are always listed by Duplicates, regardless of this option
+	WarnDuplicates bool
+
+	// Lenient enables the repair of some common violations of the standard that would otherwise abort Open, with a Warning
+	// added to the affected unit. Currently, a table extension with NAXIS other than 2 is read as a table with NAXIS1 bytes
+	// per row and NAXIS2 x NAXIS3 x ... rows (one row if NAXIS=1), which keeps the size of the data section unchanged
+	Lenient bool
+
+	// KeepRaw keeps the stored (raw) values of the pixels in Data, without applying BSCALE and BZERO. As Data is never scaled in
+	// place (see ScaledAt), this is always the case and the option has no effect; it is accepted for compatibility with the
+	// libraries that scale the data by default
+	KeepRaw bool
+
+	// MaxUnits, if positive, stops reading after the first MaxUnits HDUs (see OpenN); the rest of the file is not read
+	MaxUnits int
+}
+
 // Open processes a FITS file provided as an io.Reader and returns a list of HDUs in the FITS file
 // It is the main entry point of the fits package
 func Open(reader io.Reader) (fits []*Unit, err error) {
+	return OpenWith(reader, Options{})
+}
+
+// OpenWith is similar to Open, but its behavior is modified by opts (see Options)
+func OpenWith(reader io.Reader, opts Options) (fits []*Unit, err error) {
 	b := NewReader(reader)
+	b.opts = opts
+	return b.readUnits()
+}
+
+// OpenN is similar to Open, but stops after reading the first n HDUs, e.g. to read the primary HDU and the first extension
+// of a file with thousands of extensions. The data of each HDU read is consumed completely, so reader is left at the start
+// of the next HDU (the (n+1)'th one). A negative n means no limit (same as Open)
+func OpenN(reader io.Reader, n int) (fits []*Unit, err error) {
+	if n == 0 {
+		return []*Unit{}, nil
+	}
+	return OpenWith(reader, Options{MaxUnits: n})
+}
+
+// readUnits reads all the HDUs from b; it implements Open, OpenWith and OpenMmap
+func (b *Reader) readUnits() (fits []*Unit, err error) {
+	opts := b.opts
 	fits = make([]*Unit, 0, 5)
 done:
-	for !b.IsEOF() {
-		h, err := b.NewHeader()
+	for !b.IsEOF() && (opts.MaxUnits <= 0 || len(fits) < opts.MaxUnits) {
+		var h *Unit
+		h, err = b.NewHeader()
 		if err != nil {
 			err = nil // EOF, not an error?            
 			break
@@ -351,51 +994,231 @@ done:
 		if _, ok := h.Keys["SIMPLE"]; ok {
 			err = h.verifyPrimary()
 			if err != nil {
-				break
+				break done
 			}
 			h.class = "SIMPLE"
 			if len(h.Naxis) > 0 {
-				if h.Naxis[0] == 0 { // Random Group Headers are not supported and are not processed further
+				if h.Naxis[0] == 0 && h.Keys["GROUPS"] == true { // Random Group Headers are not supported and are not processed further
 					break done
 				}
 				err = h.loadData(b) // Imaging data
 				if err != nil {
-					break
+					break done
 				}
 			}
 		} else if xten, ok := h.Keys["XTENSION"].(string); ok {
+			if opts.Lenient {
+				h.fixTableAxes()
+			}
 			err = h.verifyExtension()
 			if err != nil {
-				break
+				break done
 			}
 			h.class = xten
+			if inherit, _ := h.Keys["INHERIT"].(bool); opts.Inherit && inherit && len(fits) > 1 && fits[0].class == "SIMPLE" {
+				h.parent = fits[0]
+			}
 			switch xten {
 			case "IMAGE":
 				if len(h.Naxis) > 0 {
 					err = h.loadData(b)
 					if err != nil {
-						break
+						break done
 					}
 				}
 			case "TABLE":
 				err = h.loadTable(b, false)
 				if err != nil {
-					break
+					break done
 				}
-			case "BINTABLE":
+			case "BINTABLE", "A3DTABLE": // A3DTABLE is the pre-standard name of binary tables used by some older files
+				h.class = "BINTABLE"
 				err = h.loadTable(b, true)
 				if err != nil {
-					break
+					break done
+				}
+			default: // other extensions (e.g. FOREIGN) are not processed, but their data, including any heap, is skipped
+				err = b.skip(int(h.dataBytes()))
+				if err != nil {
+					break done
 				}
 			}
 		} else {
 			// unknown header
-			break
+			break done
 		}
 	}
 	return fits, err
 }
 
+// OpenHeaders is similar to Open, but only reads the headers
+// The data section of each HDU is skipped by seeking past it (see dataBytes), so Data is never allocated or read
+// The returned units have populated Keys and Naxis, but Data is nil, the pixel accessor functions (At, IntAt, FloatAt and Blank)
+// return zero values (see setNoData) and AtChecked returns an error. OpenHeaders is useful for building catalogs of the metadata of many FITS files
+func OpenHeaders(r io.ReadSeeker) (fits []*Unit, err error) {
+	fits, _, err = scanHeaders(r)
+	return fits, err
+}
+
+// HDUInfo describes the location and the type of an HDU in a FITS file (see Index)
+type HDUInfo struct {
+	Index       int    // The 0-based index of the HDU in the file
+	Class       string // SIMPLE, IMAGE, TABLE or BINTABLE
+	ExtName     string // The value of EXTNAME, "" if missing
+	ByteOffset  int64  // The offset of the beginning of the header from the beginning of the file
+	HeaderBytes int64  // The size of the header in bytes, the data section starts at ByteOffset + HeaderBytes
+	DataBytes   int64  // The size of the data section in bytes without padding (see dataBytes)
+	Naxis       []int  // The dimensions (NAXISn) as in Unit.Naxis
+}
+
+// Index returns the list of HDUs in the FITS file provided by r with their location and metadata
+// Similar to OpenHeaders, it only parses the headers and seeks past the data sections
+// It is useful for viewers that show the content of a file before deciding what to load
+func Index(r io.ReadSeeker) ([]HDUInfo, error) {
+	units, offsets, err := scanHeaders(r)
+	infos := make([]HDUInfo, len(units))
+	for i, h := range units {
+		name, _ := h.Keys["EXTNAME"].(string)
+		infos[i] = HDUInfo{
+			Index:       i,
+			Class:       h.class,
+			ExtName:     name,
+			ByteOffset:  offsets[i],
+			HeaderBytes: int64(len(h.raw)),
+			DataBytes:   h.dataBytes(),
+			Naxis:       h.Naxis,
+		}
+	}
+	return infos, err
+}
+
+// scanHeaders is the helper function for OpenHeaders and Index
+// It reads the headers in r, skips the data sections, and returns the units and the offsets of the headers from the beginning of the file
+func scanHeaders(r io.ReadSeeker) (fits []*Unit, offsets []int64, err error) {
+	b := NewReader(r)
+	for !b.IsEOF() {
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fits, offsets, err
+		}
+		h, err := b.NewHeader()
+		if err != nil {
+			break // EOF
+		}
+		if _, ok := h.Keys["SIMPLE"]; ok {
+			err = h.verifyPrimary()
+			h.class = "SIMPLE"
+		} else if xten, ok := h.Keys["XTENSION"].(string); ok {
+			err = h.verifyExtension()
+			h.class = xten
+			if xten == "A3DTABLE" {
+				h.class = "BINTABLE"
+			}
+		} else {
+			break // unknown header
+		}
+		if err != nil {
+			return fits, offsets, err
+		}
+		h.setNoData()
+		fits = append(fits, h)
+		offsets = append(offsets, offset)
+
+		n := int64(h.DataBlocks()) * 2880 // the data section is padded to a multiple of 2880 bytes
+		if _, err = r.Seek(n, io.SeekCurrent); err != nil {
+			return fits, offsets, err
+		}
+	}
+	return fits, offsets, nil
+}
+
+// setNoData sets the pixel accessor functions of a unit whose data is not loaded (see OpenHeaders)
+// They do not index Data: At returns nil, IntAt and FloatAt return 0 and Blank returns true (AtChecked returns an error)
+func (h *Unit) setNoData() {
+	h.At = func(a ...int) interface{} {
+		return nil
+	}
+	h.IntAt = func(a ...int) int64 {
+		return 0
+	}
+	h.FloatAt = func(a ...int) float64 {
+		return 0
+	}
+	h.Blank = func(a ...int) bool {
+		return true
+	}
+}
+
+// dataBytes returns the size of the data section of h in bytes (without padding) based on the header keys
+// As defined by the standard, it is equal to |BITPIX|/8 * GCOUNT * (PCOUNT + NAXIS1 * NAXIS2 * ... * NAXISm)
+// NAXIS1 is excluded from the product for random groups (GROUPS=T and NAXIS1=0); otherwise, any NAXISn=0 makes the product 0
+// The result is 0 if NAXIS=0
+func (h *Unit) dataBytes() int64 {
+	if len(h.Naxis) == 0 {
+		return 0
+	}
+	bitpix, _ := h.Keys["BITPIX"].(int)
+	pcount, _ := h.Keys["PCOUNT"].(int)
+	gcount, ok := h.Keys["GCOUNT"].(int)
+	if !ok {
+		gcount = 1
+	}
+	prod := int64(1)
+	for i, x := range h.Naxis {
+		if i == 0 && x == 0 && h.Keys["GROUPS"] == true { // random groups
+			continue
+		}
+		prod *= int64(x)
+	}
+	return int64(ElementSize(bitpix)) * int64(gcount) * (int64(pcount) + prod)
+}
+
+// Index returns the index of the pixel pointed by a... in the flat Data array
+// NAXIS1 changes fastest, i.e. the index is a[0] + NAXIS1 * (a[1] + NAXIS2 * (a[2] + ...))
+// An error is returned if the number of coordinates is not equal to NAXIS or any of them is out of range
+func (h *Unit) Index(a ...int) (int, error) {
+	if err := h.checkCoords(a); err != nil {
+		return 0, err
+	}
+	return h.index(a...), nil
+}
+
+// HeaderBlocks returns the number of 2880-byte blocks occupied by the header of h
+// For units read from a file, it is the actual number of blocks read; otherwise, it is calculated based on the number of cards
+// that Write generates (Keys, including the CONTINUE cards of long strings, BlankCards and END), rounded up to a multiple of
+// 36 cards per block
+func (h *Unit) HeaderBlocks() int {
+	if len(h.raw) > 0 {
+		return len(h.raw) / 2880
+	}
+	if p, err := h.encodeHeader(); err == nil {
+		return len(p) / 2880
+	}
+	ncards := len(h.headerKeys()) + len(h.History) + len(h.Comments) + len(h.BlankCards) + 1 // +1 for END
+	if _, ok := h.Keys["HISTORY"]; ok && len(h.History) > 0 {
+		ncards-- // the HISTORY key is replaced by the History cards (see encodeHeader)
+	}
+	if _, ok := h.Keys["COMMENT"]; ok && len(h.Comments) > 0 {
+		ncards-- // the same for COMMENT
+	}
+	return (ncards + 35) / 36
+}
+
+// DataBlocks returns the number of 2880-byte blocks occupied by the data section of h, based on dataBytes
+func (h *Unit) DataBlocks() int {
+	return int((h.dataBytes() + 2879) / 2880)
+}
+
+// TotalBytes returns the expected size in bytes of a FITS file made of units, i.e. the sum of the header and data blocks
+// of all the units times 2880. It only depends on the header keys, so it works for units read by OpenHeaders as well
+func TotalBytes(units []*Unit) int64 {
+	var n int64
+	for _, h := range units {
+		n += int64(h.HeaderBlocks()+h.DataBlocks()) * 2880
+	}
+	return n
+}
+
 // index is a helper function the returns the index of the pixel pointed by a... in a flat Data array
 func (h *Unit) index(a ...int) int {
 	var index int
@@ -405,6 +1228,35 @@ func (h *Unit) index(a ...int) int {
 	return index
 }
 
+// AtChecked is similar to At, but validates the coordinates against Naxis and returns an error instead of panicking
+// (or silently returning a wrong pixel) if the number of coordinates is not equal to NAXIS or any of them is out of range
+// It is useful when the coordinates come from user input; At, IntAt and FloatAt remain the fast paths
+func (h *Unit) AtChecked(a ...int) (interface{}, error) {
+	if h.Data == nil && h.At != nil {
+		return nil, fmt.Errorf("Data is not loaded (the unit is read by OpenHeaders)")
+	}
+	if err := h.checkCoords(a); err != nil {
+		return nil, err
+	}
+	if h.At == nil {
+		return nil, fmt.Errorf("Unit has no image data")
+	}
+	return h.At(a...), nil
+}
+
+// checkCoords verifies that a holds valid pixel coordinates for the image in h
+func (h *Unit) checkCoords(a []int) error {
+	if len(a) != len(h.Naxis) {
+		return fmt.Errorf("Expected %d coordinates, got %d", len(h.Naxis), len(a))
+	}
+	for k, x := range a {
+		if x < 0 || x >= h.Naxis[k] {
+			return fmt.Errorf("Coordinate %d (%d) is out of range [0, %d)", k+1, x, h.Naxis[k])
+		}
+	}
+	return nil
+}
+
 // loadData processes the image type data sections
 // It allocates Data, populates it, and sets the appropriate pixel accessor functions
 func (h *Unit) loadData(b *Reader) error {
@@ -427,12 +1279,103 @@ func (h *Unit) loadData(b *Reader) error {
 	}
 
 	bitpix := h.Keys["BITPIX"].(int)
+	if prod == 0 { // a degenerate axis (NAXISn=0), e.g. a placeholder extension
+		h.setEmpty(bitpix)
+		return nil
+	}
+
+	if pos := b.offset(); bitpix == 8 && b.mapped != nil && pos+prod <= len(b.mapped) {
+		h.Data = b.mapped[pos : pos+prod] // zero-copy, see OpenMmap
+		if err := b.skip(prod); err != nil {
+			return err
+		}
+		h.setAccessors()
+		return nil
+	}
+
+	// the whole data section is read at once and then decoded, which is much faster than reading the pixels one by one
+	// b.Read takes care of the block boundaries, so the pixels straddling two blocks are handled correctly
+	raw := make([]byte, prod*ElementSize(bitpix))
+	if n, _ := b.Read(raw); n < len(raw) {
+		return ErrTruncated{len(raw), n}
+	}
 
 	switch bitpix {
 	case 8:
-		data := make([]byte, prod) // Data type is determined based on bitpix
+		h.Data = raw // Data type is determined based on bitpix
+	case 16:
+		data := make([]int16, prod)
+		for i = range data {
+			data[i] = int16(binary.BigEndian.Uint16(raw[2*i:]))
+		}
+		h.Data = data
+	case 32:
+		data := make([]int32, prod)
+		for i = range data {
+			data[i] = int32(binary.BigEndian.Uint32(raw[4*i:]))
+		}
+		h.Data = data
+	case 64:
+		data := make([]int64, prod)
+		for i = range data {
+			data[i] = int64(binary.BigEndian.Uint64(raw[8*i:]))
+		}
+		h.Data = data
+	case -32:
+		data := make([]float32, prod)
+		for i = range data {
+			data[i] = math.Float32frombits(binary.BigEndian.Uint32(raw[4*i:]))
+		}
+		h.Data = data
+	case -64:
+		data := make([]float64, prod)
+		for i = range data {
+			data[i] = math.Float64frombits(binary.BigEndian.Uint64(raw[8*i:]))
+		}
 		h.Data = data
-		h.At = func(a ...int) interface{} { // The accessor functions look similar, but note that data is redefined and has a different type for each case
+	}
+
+	h.setAccessors()
+	return nil
+}
+
+// setEmpty sets Data to an empty array of the type determined by bitpix for images with no pixels (any NAXISn=0)
+// There is no valid coordinate for such images, so the accessor functions do not index Data: At returns nil,
+// IntAt and FloatAt return 0 and Blank returns true
+func (h *Unit) setEmpty(bitpix int) {
+	switch bitpix {
+	case 8:
+		h.Data = []byte{}
+	case 16:
+		h.Data = []int16{}
+	case 32:
+		h.Data = []int32{}
+	case 64:
+		h.Data = []int64{}
+	case -32:
+		h.Data = []float32{}
+	case -64:
+		h.Data = []float64{}
+	}
+	h.At = func(a ...int) interface{} {
+		return nil
+	}
+	h.IntAt = func(a ...int) int64 {
+		return 0
+	}
+	h.FloatAt = func(a ...int) float64 {
+		return 0
+	}
+	h.Blank = func(a ...int) bool {
+		return true
+	}
+}
+
+// setAccessors sets the pixel accessor functions (At, IntAt, FloatAt and Blank) based on the type of Data
+func (h *Unit) setAccessors() {
+	switch data := h.Data.(type) {
+	case []byte:
+		h.At = func(a ...int) interface{} { // The accessor functions look similar, but note that data has a different type for each case
 			// Templates (generics) would have helped with cutting back on redundant code!
 			return data[h.index(a...)]
 		}
@@ -442,12 +1385,7 @@ func (h *Unit) loadData(b *Reader) error {
 		h.FloatAt = func(a ...int) float64 {
 			return float64(data[h.index(a...)])
 		}
-		for i = 0; i < prod; i++ {
-			data[i] = b.ReadByte()
-		}
-	case 16:
-		data := make([]int16, prod)
-		h.Data = data
+	case []int16:
 		h.At = func(a ...int) interface{} {
 			return data[h.index(a...)]
 		}
@@ -457,12 +1395,7 @@ func (h *Unit) loadData(b *Reader) error {
 		h.FloatAt = func(a ...int) float64 {
 			return float64(data[h.index(a...)])
 		}
-		for i = 0; i < prod; i++ {
-			data[i] = b.ReadInt16()
-		}
-	case 32:
-		data := make([]int32, prod)
-		h.Data = data
+	case []int32:
 		h.At = func(a ...int) interface{} {
 			return data[h.index(a...)]
 		}
@@ -472,12 +1405,7 @@ func (h *Unit) loadData(b *Reader) error {
 		h.FloatAt = func(a ...int) float64 {
 			return float64(data[h.index(a...)])
 		}
-		for i = 0; i < prod; i++ {
-			data[i] = b.ReadInt32()
-		}
-	case 64:
-		data := make([]int64, prod)
-		h.Data = data
+	case []int64:
 		h.At = func(a ...int) interface{} {
 			return data[h.index(a...)]
 		}
@@ -487,12 +1415,7 @@ func (h *Unit) loadData(b *Reader) error {
 		h.FloatAt = func(a ...int) float64 {
 			return float64(data[h.index(a...)])
 		}
-		for i = 0; i < prod; i++ {
-			data[i] = b.ReadInt64()
-		}
-	case -32:
-		data := make([]float32, prod)
-		h.Data = data
+	case []float32:
 		h.At = func(a ...int) interface{} {
 			return data[h.index(a...)]
 		}
@@ -502,12 +1425,7 @@ func (h *Unit) loadData(b *Reader) error {
 		h.FloatAt = func(a ...int) float64 {
 			return float64(data[h.index(a...)])
 		}
-		for i = 0; i < prod; i++ {
-			data[i] = b.ReadFloat32()
-		}
-	case -64:
-		data := make([]float64, prod)
-		h.Data = data
+	case []float64:
 		h.At = func(a ...int) interface{} {
 			return data[h.index(a...)]
 		}
@@ -517,15 +1435,16 @@ func (h *Unit) loadData(b *Reader) error {
 		h.FloatAt = func(a ...int) float64 {
 			return float64(data[h.index(a...)])
 		}
-		for i = 0; i < prod; i++ {
-			data[i] = b.ReadFloat64()
-		}
 	}
 
-	blank, ok := h.Keys["BLANK"]
+	bitpix := h.Keys["BITPIX"].(int)
+	blank, ok := h.blankValue()
+	if _, defined := h.Keys["BLANK"]; defined && !ok && bitpix > 0 {
+		h.warn("BLANK", "BLANK is not a valid value for BITPIX=%d and is ignored", bitpix)
+	}
 	switch {
-	case ok && bitpix > 0: // Integer pixel type with defined BLANK
-		h.blank = blank.(int)
+	case ok: // Integer pixel type with defined BLANK
+		h.blank = blank
 		h.Blank = func(a ...int) bool {
 			return h.IntAt(a...) == int64(h.blank)
 		}
@@ -538,15 +1457,35 @@ func (h *Unit) loadData(b *Reader) error {
 			return false
 		}
 	}
-
-	return nil
+}
+
+// blankValue returns the value of BLANK key, which is only meaningful for integral pixel types (BITPIX > 0)
+// ok is false if BLANK is missing, the pixels are float, or BLANK is out of the range of the pixel type
+// (e.g. BLANK=-1 for BITPIX=8, since byte pixels are unsigned and range from 0 to 255)
+func (h *Unit) blankValue() (blank int, ok bool) {
+	blank, ok = h.Keys["BLANK"].(int)
+	if !ok {
+		return 0, false
+	}
+	switch h.Keys["BITPIX"] {
+	case 8:
+		ok = blank >= 0 && blank <= math.MaxUint8
+	case 16:
+		ok = blank >= math.MinInt16 && blank <= math.MaxInt16
+	case 32, 64:
+		ok = true
+	default:
+		ok = false
+	}
+	return blank, ok
 }
 
 // accessorBin generates the accessor function for a field in a binary table (XTENSION=BINTABLE)
 // loadTable function processes TFORM for each field 
 // For binary tables, TFORM is like rT, where r is the repeat and T is the type code
 // With the exception of code='A' (string-type), the accessor functions are different for repeat=1 (returns an atomic value) vs repeat>1 (returns a fixed array)
-// Note, variable arrays (type P and Q) and packed bits (type X) are not supported in the current version 
+// For variable length arrays (type P and Q), the accessor functions return the array descriptor (see Descriptor)
+// Note, packed bits (type X) are not decoded and the accessor functions return the raw bytes holding the bits as []uint8 
 // col is the byte index of the value of the field from the beginning of each record
 func (h *Unit) accessorBin(code byte, repeat int, col *int) (fn func(int) interface{}, disp string) {
 	c := *col
@@ -719,11 +1658,37 @@ func (h *Unit) accessorBin(code byte, repeat int, col *int) (fn func(int) interf
 		}
 		l = 8
 		disp = "F14.7"
-	case 'X', 'P', 'Q':
-		panic("Binary table forms X, P and Q are not supported")
+	case 'P':
+		f = func() interface{} { // only the descriptor is read, the array itself is stored in the heap
+			n := b.ReadInt32()
+			offset := b.ReadInt32()
+			return Descriptor{int64(n), int64(offset)}
+		}
+		l = 8
+		disp = "A20"
+	case 'Q':
+		f = func() interface{} {
+			n := b.ReadInt64()
+			offset := b.ReadInt64()
+			return Descriptor{n, offset}
+		}
+		l = 16
+		disp = "A20"
+	case 'X':
+		n := (repeat + 7) / 8
+		f = func() interface{} { // packed bits are not decoded, the accessor returns the bytes holding the bits
+			p := make([]uint8, n)
+			b.Read(p)
+			return p
+		}
+		disp = "Z2"
 	}
 
-	*col += l * repeat
+	if code == 'X' {
+		*col += (repeat + 7) / 8
+	} else {
+		*col += l * repeat
+	}
 
 	// fn is the actual FieldFunc
 	// it sets b.left based on the record size and row and calls f to extract the field value
@@ -745,6 +1710,7 @@ func (h *Unit) accessorBin(code byte, repeat int, col *int) (fn func(int) interf
 // accessorText generates the accessor function for a field in a text table (XTENSION=TABLE)
 // loadTable function processes TFORM for each field 
 // For text tables, TFORM is like Tw or Tw.d (T=code and w=repeat)
+// The accessor functions return string (A), int (Iw with w <= 9), int64 (Iw with w > 9) or float64 (D, E and F)
 func (h *Unit) accessorText(code byte, repeat int, col *int) (fn func(int) interface{}, disp string) {
 	c := *col - 1
 	var f func() interface{}
@@ -758,10 +1724,14 @@ func (h *Unit) accessorText(code byte, repeat int, col *int) (fn func(int) inter
 			return b.ReadString(repeat)
 		}
 		disp = fmt.Sprintf("A%d", repeat)
-	case 'I':
+	case 'I': // the values of fields wider than 9 characters may not fit in 32 bits and are returned as int64
 		f = func() interface{} {
 			s := b.ReadString(repeat)
 			s = strings.TrimSpace(s)
+			if repeat > 9 {
+				n, _ := strconv.ParseInt(s, 10, 64)
+				return n
+			}
 			n, _ := strconv.ParseInt(s, 10, 32)
 			return int(n)
 		}
@@ -769,9 +1739,7 @@ func (h *Unit) accessorText(code byte, repeat int, col *int) (fn func(int) inter
 	case 'D', 'E', 'F':
 		f = func() interface{} {
 			s := b.ReadString(repeat)
-			s = strings.TrimSpace(s)
-			s = strings.Replace(s, "D", "E", 1)
-			x, _ := strconv.ParseFloat(s, 64)
+			x, _ := parseFortranFloat(s)
 			return x
 		}
 		disp = "F14.7"
@@ -795,28 +1763,41 @@ func (h *Unit) accessorText(code byte, repeat int, col *int) (fn func(int) inter
 	return fn, disp
 }
 
+// parseFortranFloat parses a floating point number written by a Fortran-style formatter
+// Leading and trailing spaces are ignored, and the exponent character can be 'E', 'e', 'D' or 'd' (e.g. "1.5d3" or "-2.0D-4")
+func parseFortranFloat(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.Map(func(r rune) rune {
+		if r == 'D' || r == 'd' {
+			return 'E'
+		}
+		return r
+	}, s)
+	return strconv.ParseFloat(s, 64)
+}
+
 // verifyPrimary verifies a primary (SIMPLE) header for correctness and the presence of mandatory keys
 func (h *Unit) verifyPrimary() error {
 	_, ok := h.Keys["SIMPLE"]
 	if !ok {
-		return fmt.Errorf("No SIMPLE in the primary header")
+		return ErrMissingKeyword{"SIMPLE"}
 	}
 	n, ok := h.Keys["BITPIX"].(int)
 	if !ok {
-		return fmt.Errorf("No BITPIX in the primary header")
+		return ErrMissingKeyword{"BITPIX"}
 	}
-	if n != 8 && n != 16 && n != 32 && n != 64 && n != -32 && n == -64 {
-		return fmt.Errorf("Invalid BITPIX value")
+	if typeName(n) == "" {
+		return ErrBadValue{"BITPIX", strconv.Itoa(n)}
 	}
 	n, ok = h.Keys["NAXIS"].(int)
 	if !ok {
-		return fmt.Errorf("No NAXIS in the primary header")
+		return ErrMissingKeyword{"NAXIS"}
 	}
 	for i := 1; i <= n; i++ {
 		s := Nth("NAXIS", i)
 		_, ok := h.Keys[s].(int)
 		if !ok {
-			return fmt.Errorf("No %v in the primary header", s)
+			return ErrMissingKeyword{s}
 		}
 	}
 	return nil
@@ -826,61 +1807,105 @@ func (h *Unit) verifyPrimary() error {
 func (h *Unit) verifyExtension() error {
 	xten, ok := h.Keys["XTENSION"].(string)
 	if !ok {
-		return fmt.Errorf("No XTENSION in the extended header")
+		return ErrMissingKeyword{"XTENSION"}
 	}
 	n, ok := h.Keys["BITPIX"].(int)
 	if !ok {
-		return fmt.Errorf("No BITPIX in the extended header")
+		return ErrMissingKeyword{"BITPIX"}
 	}
-	if n != 8 && n != 16 && n != 32 && n != 64 && n != -32 && n == -64 {
-		return fmt.Errorf("Invalid BITPIX value")
+	if typeName(n) == "" {
+		return ErrBadValue{"BITPIX", strconv.Itoa(n)}
 	}
 	naxis, ok := h.Keys["NAXIS"].(int)
 	if !ok {
-		return fmt.Errorf("No NAXIS in the extended header")
+		return ErrMissingKeyword{"NAXIS"}
 	}
 	for i := 1; i <= naxis; i++ {
 		s := Nth("NAXIS", i)
 		_, ok := h.Keys[s].(int)
 		if !ok {
-			return fmt.Errorf("No %v in the extended header", s)
+			return ErrMissingKeyword{s}
 		}
 	}
 	pcount, ok := h.Keys["PCOUNT"].(int)
 	if !ok {
-		return fmt.Errorf("No PCOUNT in the extended header")
+		return ErrMissingKeyword{"PCOUNT"}
 	}
 	_, ok = h.Keys["GCOUNT"].(int)
 	if !ok {
-		return fmt.Errorf("No GCOUNT in the extended header")
+		return ErrMissingKeyword{"GCOUNT"}
 	}
 	switch xten {
 	case "IMAGE":
 		if pcount != 0 {
-			return fmt.Errorf("PCOUNT should be 0 in IMAGE header")
+			return ErrBadValue{"PCOUNT", strconv.Itoa(pcount)} // PCOUNT should be 0 in IMAGE headers
 		}
-	case "TABLE", "BINTABLE":
+	case "TABLE", "BINTABLE", "A3DTABLE":
 		if n != 8 {
-			return fmt.Errorf("BITPIX should be 8 in TABLE/BINTABLE headers")
+			return ErrBadValue{"BITPIX", strconv.Itoa(n)} // BITPIX should be 8 in TABLE/BINTABLE headers
 		}
 		if naxis != 2 {
-			return fmt.Errorf("NAXIS should be 2 in TABLE/BINTABLE headers")
+			return ErrBadValue{"NAXIS", strconv.Itoa(naxis)} // NAXIS should be 2 in TABLE/BINTABLE headers
 		}
 	}
 	return nil
 }
 
+// fixTableAxes repairs the axes of a table extension with NAXIS other than 2 in the lenient mode (see Options)
+// NAXIS1 is kept as the row width and the rest of the axes are combined into NAXIS2, so the data size does not change
+func (h *Unit) fixTableAxes() {
+	xten, _ := h.Keys["XTENSION"].(string)
+	naxis, _ := h.Keys["NAXIS"].(int)
+	if (xten != "TABLE" && xten != "BINTABLE" && xten != "A3DTABLE") || naxis == 2 || len(h.Naxis) == 0 {
+		return
+	}
+	rows := 1
+	for _, x := range h.Naxis[1:] {
+		rows *= x
+	}
+	h.warn("NAXIS", "NAXIS=%d in a %v header, the data is read as a table of %d rows", naxis, xten, rows)
+	for i := 3; i <= naxis; i++ {
+		delete(h.Keys, Nth("NAXIS", i))
+	}
+	h.Naxis = []int{h.Naxis[0], rows}
+	h.Keys["NAXIS"] = 2
+	h.Keys["NAXIS2"] = rows
+}
+
 // loadTable processes a table (text or binary) data section
-// it allocates and reads data
-// for each field, it calls accessorBin or accessorText to obtain the corresponding accessor function and adds it to fields
+// it allocates and reads data and then calls buildTable to setup the fields
+// The fields should fit in a row: an error is returned if NAXIS1 is less than the sum of the field widths (binary)
+// or the end of the rightmost field (text), but extra padding bytes at the end of each row are allowed
 func (h *Unit) loadTable(b *Reader, binary bool) error {
+	data := make([]byte, h.Naxis[0]*h.Naxis[1])
+	n, _ := b.Read(data)
+	h.Data = data
+	if n < len(data) {
+		return ErrTruncated{len(data), n}
+	}
+	if pcount, _ := h.Keys["PCOUNT"].(int); binary && pcount > 0 {
+		h.heap = make([]byte, pcount)
+		m, _ := b.Read(h.heap)
+		if m < pcount {
+			return ErrTruncated{len(data) + pcount, len(data) + m}
+		}
+	}
+
+	if err := h.verifyTable(); err != nil {
+		return err
+	}
+
+	return h.buildTable(binary)
+}
+
+// buildTable sets up the fields of a table based on the header keys, assuming Data is already populated
+// for each field, it calls accessorBin or accessorText to obtain the corresponding accessor function and adds it to fields
+func (h *Unit) buildTable(binary bool) error {
 	tfields := h.Keys["TFIELDS"].(int) // # of fields
 	h.list = make([]FieldFunc, tfields)
 	h.fields = make(map[string]FieldFunc, tfields)
-
-	data := make([]byte, h.Naxis[0]*h.Naxis[1])
-	b.Read(data)
-	h.Data = data
+	h.names = make(map[string]int, tfields)
+	h.columns = make([]column, tfields)
 
 	var col int
 	for i := 0; i < tfields; i++ {
@@ -890,17 +1915,34 @@ func (h *Unit) loadTable(b *Reader, binary bool) error {
 		form := h.Keys[Nth("TFORM", i+1)].(string)
 
 		if binary { // BINTABLE
-			j = strings.IndexAny(form, "ABCDEIJKLMPQX")
-			if j == -1 {
-				return fmt.Errorf("TFROM has invalid format (binary)")
+			code, repeat, err := binaryForm(form)
+			if err != nil {
+				return err
 			}
-			repeat := 1
-			if j > 0 {
-				r, _ := strconv.ParseInt(form[:j], 10, 32)
-				repeat = int(r)
+			h.columns[i] = column{code: code, repeat: repeat, offset: col}
+			if code == 'P' || code == 'Q' {
+				if h.columns[i].elem, err = varElem(form); err != nil {
+					return err
+				}
 			}
 			if repeat > 0 {
-				fn, disp = h.accessorBin(form[j], repeat, &col)
+				fn, disp = h.accessorBin(code, repeat, &col)
+				if code == 'B' && h.isSignedByte(i+1) {
+					fn = signedBytes(fn)
+				} else if h.isUnsigned(i+1, code) {
+					fn = unsignedInts(fn)
+				} else if tscal, tzero, scaled, integral := h.fieldScaling(i + 1); scaled && strings.IndexByte("BIJK", code) != -1 {
+					fn = scaledInts(fn, tscal, tzero, integral)
+				}
+				if dims := h.tdim(i + 1); code == 'A' && len(dims) == 2 && dims[0]*dims[1] <= repeat {
+					fn = splitStrings(fn, dims[0], dims[1]) // an array of dims[1] strings, each dims[0] characters long
+				}
+				if h.columns[i].elem != 0 {
+					h.columns[i].descs = make([]Descriptor, h.Naxis[1])
+					for row := range h.columns[i].descs {
+						h.columns[i].descs[row] = fn(row).(Descriptor)
+					}
+				}
 			} else {
 				continue
 			}
@@ -909,29 +1951,483 @@ func (h *Unit) loadTable(b *Reader, binary bool) error {
 			if j == -1 {
 				j = len(form)
 			}
-			r, _ := strconv.ParseInt(form[1:j], 10, 32)
+			r, err := strconv.ParseInt(form[1:j], 10, 32)
+			if err != nil || r <= 0 { // the width is mandatory for text tables (e.g. a bare A instead of A20)
+				return ErrUnsupportedForm{form}
+			}
 			col = h.Keys[Nth("TBCOL", i+1)].(int)
-			fn, disp = h.accessorText(form[0], int(r), &col)
+			code := byte(unicode.ToUpper(rune(form[0]))) // accept lowercase type codes
+			h.columns[i] = column{code: code, repeat: int(r), offset: col - 1}
+			fn, disp = h.accessorText(code, int(r), &col)
 		}
 
 		h.list[i] = fn
 		name, ok := h.Keys[Nth("TTYPE", i+1)]
-		if ok {
+		if _, dup := h.names[fmt.Sprint(name)]; ok && dup { // only the first field with a given name is reachable by name
+			h.warn(Nth("TTYPE", i+1), "duplicate field name %v, use FieldN or the field index to access field %d", name, i)
+		} else if ok {
 			h.fields[name.(string)] = fn
-			h.Keys["#"+name.(string)] = i + 1 // is used to find the index of a field if only its name is given
+			h.names[name.(string)] = i + 1 // is used to find the index of a field if only its name is given
 		} else {
-			h.Keys[Nth("TTYPE", i+1)] = Nth("COL", i+1) // default name given to fields without a corresponding TTYPE
+			h.setDefault(Nth("TTYPE", i+1), Nth("COL", i+1)) // default name given to fields without a corresponding TTYPE
 		}
 
 		_, ok = h.Keys[Nth("TDISP", i+1)]
 		if !ok {
-			h.Keys[Nth("TDISP", i+1)] = disp // if TDISP is missing, the default disp is added to the header as a TDISP
+			h.setDefault(Nth("TDISP", i+1), disp) // if TDISP is missing, the default disp is added to the header as a TDISP
 		}
 	}
 
 	return nil
 }
 
+// setDefault adds a key that is missing from the header to Keys and records it in h.defaults, so it is not written by Write
+func (h *Unit) setDefault(key string, value interface{}) {
+	h.Keys[key] = value
+	if h.defaults == nil {
+		h.defaults = make(map[string]interface{})
+	}
+	h.defaults[key] = value
+}
+
+// isDefault returns true if key was added by buildTable (see setDefault) and still has the value it was given
+func (h *Unit) isDefault(key string) bool {
+	value, ok := h.defaults[key]
+	return ok && h.Keys[key] == value
+}
+
+// isSignedByte returns true if the k'th field (1-based) follows the convention for storing signed bytes (int8) in a byte field,
+// i.e. TZEROk=-128 and TSCALk=1 (or missing)
+func (h *Unit) isSignedByte(k int) bool {
+	tzero, _ := h.floatKey(Nth("TZERO", k))
+	tscal, ok := h.floatKey(Nth("TSCAL", k))
+	return tzero == -128 && (!ok || tscal == 1)
+}
+
+// signedBytes wraps the accessor function of a byte field (TFORM=rB) with TZERO=-128 and returns the physical values as int8 or []int8
+// As defined by the standard, the physical value is the stored value plus TZERO (e.g. the stored value 200 is read as 72)
+func signedBytes(fn FieldFunc) FieldFunc {
+	return func(row int) interface{} {
+		switch x := fn(row).(type) {
+		case uint8:
+			return int8(x ^ 0x80) // the same as x - 128
+		case []uint8:
+			p := make([]int8, len(x))
+			for i := range x {
+				p[i] = int8(x[i] ^ 0x80)
+			}
+			return p
+		default:
+			return x
+		}
+	}
+}
+
+// unsignedZero maps the signed integer type codes of binary tables to the value of TZERO that marks the convention for
+// storing unsigned integers, e.g. TZERO=32768 for uint16 stored in an I field
+var unsignedZero = map[byte]float64{'I': 1 << 15, 'J': 1 << 31, 'K': 1 << 63}
+
+// isUnsigned returns true if the k'th field (1-based) of type code follows the convention for storing unsigned integers,
+// i.e. TZEROk is 32768 (I), 2147483648 (J) or 9223372036854775808 (K) and TSCALk=1 (or missing)
+func (h *Unit) isUnsigned(k int, code byte) bool {
+	zero, ok := unsignedZero[code]
+	tscal, tzero, _, _ := h.fieldScaling(k)
+	return ok && tscal == 1 && tzero == zero
+}
+
+// unsignedInts wraps the accessor function of an integer field that follows the convention for storing unsigned integers
+// (see isUnsigned) and returns the physical values as uint16, uint32 or uint64 (or a slice of them) for I, J and K respectively
+// Adding TZERO to the stored value is the same as flipping its sign bit (e.g. the stored value -32768 is read as 0)
+func unsignedInts(fn FieldFunc) FieldFunc {
+	return func(row int) interface{} {
+		switch x := fn(row).(type) {
+		case int16:
+			return uint16(x) ^ 0x8000
+		case int32:
+			return uint32(x) ^ 0x80000000
+		case int64:
+			return uint64(x) ^ 0x8000000000000000
+		case []int16:
+			p := make([]uint16, len(x))
+			for i := range x {
+				p[i] = uint16(x[i]) ^ 0x8000
+			}
+			return p
+		case []int32:
+			p := make([]uint32, len(x))
+			for i := range x {
+				p[i] = uint32(x[i]) ^ 0x80000000
+			}
+			return p
+		case []int64:
+			p := make([]uint64, len(x))
+			for i := range x {
+				p[i] = uint64(x[i]) ^ 0x8000000000000000
+			}
+			return p
+		default:
+			return x
+		}
+	}
+}
+
+// fieldScaling returns TSCALk and TZEROk of the k'th field (1-based), which default to 1 and 0 respectively
+// scaled is false if both have their default values, and integral is true if both are integers
+func (h *Unit) fieldScaling(k int) (tscal, tzero float64, scaled, integral bool) {
+	tscal, ok := h.floatKey(Nth("TSCAL", k))
+	if !ok {
+		tscal = 1
+	}
+	tzero, _ = h.floatKey(Nth("TZERO", k))
+	scaled = tscal != 1 || tzero != 0
+	integral = tscal == math.Trunc(tscal) && tzero == math.Trunc(tzero)
+	return
+}
+
+// scaledInts wraps the accessor function of an integer field (TFORM=rB, rI, rJ or rK) with TSCAL or TZERO and returns the
+// physical values, i.e. TZERO + TSCAL * stored value. The type of the result is decided by the scaling: int64 (or []int64)
+// if both TSCAL and TZERO are integers, and float64 (or []float64) otherwise (e.g. TSCAL=0.001), regardless of the stored type
+func scaledInts(fn FieldFunc, tscal, tzero float64, integral bool) FieldFunc {
+	scale := func(v reflect.Value) interface{} {
+		var x int64
+		if v.Kind() == reflect.Uint8 {
+			x = int64(v.Uint())
+		} else {
+			x = v.Int()
+		}
+		if integral {
+			return int64(tzero) + int64(tscal)*x
+		}
+		return tzero + tscal*float64(x)
+	}
+	return func(row int) interface{} {
+		v := reflect.ValueOf(fn(row))
+		switch v.Kind() {
+		case reflect.Uint8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return scale(v)
+		case reflect.Slice:
+			if integral {
+				p := make([]int64, v.Len())
+				for i := range p {
+					p[i] = scale(v.Index(i)).(int64)
+				}
+				return p
+			}
+			p := make([]float64, v.Len())
+			for i := range p {
+				p[i] = scale(v.Index(i)).(float64)
+			}
+			return p
+		}
+		return fn(row)
+	}
+}
+
+// splitStrings wraps the accessor function of a string field (TFORM=rA) declared as a two-dimensional array by TDIM (e.g. '(8,10)')
+// The returned accessor function splits each cell into n substrings of width w and returns them as a []string
+func splitStrings(fn FieldFunc, w int, n int) FieldFunc {
+	return func(row int) interface{} {
+		s, ok := fn(row).(string)
+		if !ok {
+			return nil
+		}
+		p := make([]string, n)
+		for i := range p {
+			p[i] = s[i*w : (i+1)*w]
+		}
+		return p
+	}
+}
+
+// FilterRows returns a new table unit containing the rows of h for which pred returns true
+// pred receives h and the row number and can use Field to inspect the cell values, e.g.
+//
+//      flux := h.Field("FLUX")
+//      g, err := h.FilterRows(func(h *fits.Unit, row int) bool {
+//          return flux(row).(float32) > 0
+//      })
+//
+// The rows are copied intact (including fixed-size arrays) into a new Data, NAXIS2 is updated and the accessor functions are rebuilt
+func (h *Unit) FilterRows(pred func(h *Unit, row int) bool) (*Unit, error) {
+	if !h.HasTable() {
+		return nil, fmt.Errorf("FilterRows needs a TABLE or BINTABLE unit")
+	}
+	width := h.Naxis[0]
+	src := h.Data.([]byte)
+	data := make([]byte, 0, len(src))
+	n := 0 // the number of rows in the new table
+	for row := 0; row < h.Naxis[1]; row++ {
+		if pred(h, row) {
+			data = append(data, src[row*width:(row+1)*width]...)
+			n++
+		}
+	}
+
+	g := &Unit{
+		Keys:       copyKeys(h.Keys),
+		Naxis:      []int{width, n},
+		Data:       data,
+		class:      h.class,
+		BlankCards: append([]string(nil), h.BlankCards...),
+		History:    append([]string(nil), h.History...),
+		Comments:   append([]string(nil), h.Comments...),
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+		defaults:   copyKeys(h.defaults),
+	}
+	g.Keys["NAXIS2"] = g.Naxis[1]
+	if len(h.heap) > 0 {
+		g.heap = append([]byte(nil), h.heap...)
+		if theap, ok := g.Keys["THEAP"].(int); ok { // keeps the gap between the main table and the heap unchanged
+			g.Keys["THEAP"] = theap - width*(h.Naxis[1]-n)
+		}
+	}
+	if err := g.buildTable(h.class != "TABLE"); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// columnKeys is the list of the indexed keys that describe table fields (e.g. TTYPEn and TFORMn)
+var columnKeys = []string{"TTYPE", "TFORM", "TBCOL", "TUNIT", "TSCAL", "TZERO", "TNULL", "TDISP", "TDIM",
+	"TDMIN", "TDMAX", "TLMIN", "TLMAX", "TCTYP", "TCUNI", "TCRPX", "TCRVL", "TCDLT", "TCROT"}
+
+// SelectColumns returns a new table unit containing only the fields given by cols
+// Each col can be an int or a string (same as Field). The fields are placed in the order they appear in cols
+// The field keys (TTYPEn, TFORMn, TBCOLn, TUNITn,...) are renumbered, NAXIS1 and TFIELDS are recomputed and the data is repacked
+// The variable length arrays (TFORM P and Q) of the selected fields are copied to a new heap, which starts right after the
+// main table (no THEAP), and their descriptors are updated accordingly
+func (h *Unit) SelectColumns(cols ...interface{}) (*Unit, error) {
+	if !h.HasTable() {
+		return nil, fmt.Errorf("SelectColumns needs a TABLE or BINTABLE unit")
+	}
+	binary := h.class != "TABLE"
+
+	index := make([]int, len(cols))
+	width := 0 // the width of the new rows (NAXIS1)
+	for i, col := range cols {
+		n := h.fieldIndex(col)
+		if n == -1 {
+			return nil, fmt.Errorf("Field %v not found", col)
+		}
+		index[i] = n
+		width += h.columns[n].width(binary)
+	}
+
+	keys := copyKeys(h.Keys)
+	for k := 1; k <= len(h.columns); k++ {
+		for _, prefix := range columnKeys {
+			delete(keys, Nth(prefix, k))
+		}
+	}
+
+	nrows := h.Naxis[1]
+	src := h.Data.([]byte)
+	data := make([]byte, width*nrows)
+	var heap []byte
+	offset := 0
+	for i, n := range index {
+		for _, prefix := range columnKeys {
+			if value, ok := h.Keys[Nth(prefix, n+1)]; ok && !h.isDefault(Nth(prefix, n+1)) { // buildTable adds the defaults again
+				keys[Nth(prefix, i+1)] = value
+			}
+		}
+		c := h.columns[n]
+		w := c.width(binary)
+		if !binary {
+			keys[Nth("TBCOL", i+1)] = offset + 1
+		}
+		for row := 0; row < nrows; row++ {
+			cell := data[row*width+offset : row*width+offset+w]
+			copy(cell, src[row*h.Naxis[0]+c.offset:])
+			if c.elem != 0 {
+				var err error
+				if heap, err = h.copyArray(heap, c, row, cell); err != nil {
+					return nil, fmt.Errorf("Field %v: %v", cols[i], err)
+				}
+			}
+		}
+		offset += w
+	}
+	keys["TFIELDS"] = len(cols)
+	keys["NAXIS1"] = width
+	if binary { // only the arrays of the selected fields are kept in the heap
+		keys["PCOUNT"] = len(heap)
+		delete(keys, "THEAP")
+	}
+
+	g := &Unit{
+		Keys:       keys,
+		Naxis:      []int{width, nrows},
+		Data:       data,
+		class:      h.class,
+		BlankCards: append([]string(nil), h.BlankCards...),
+		History:    append([]string(nil), h.History...),
+		Comments:   append([]string(nil), h.Comments...),
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+		heap:       heap,
+	}
+	if err := g.buildTable(binary); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// copyArray is a helper function for SelectColumns that appends the variable length array of column c in row to heap and
+// writes its offset in the new heap to the descriptor in cell (the count is unchanged). It returns the extended heap
+func (h *Unit) copyArray(heap []byte, c column, row int, cell []byte) ([]byte, error) {
+	d := c.descs[row]
+	size := int64(binarySize(c.elem, int(d.Count)))
+	start := int64(h.heapGap()) + d.Offset
+	if d.Count < 0 || d.Offset < 0 || start+size > int64(len(h.heap)) {
+		return nil, fmt.Errorf("Descriptor %v of row %d points outside the heap", d, row)
+	}
+	offset := len(heap)
+	if c.code == 'P' && int64(offset)+size > math.MaxInt32 {
+		return nil, fmt.Errorf("Heap is too large for the 32-bit descriptors (use Q instead of P)")
+	}
+	heap = append(heap, h.heap[start:start+size]...)
+	if c.code == 'P' {
+		binary.BigEndian.PutUint32(cell[4:], uint32(offset))
+	} else {
+		binary.BigEndian.PutUint64(cell[8:], uint64(offset))
+	}
+	return heap, nil
+}
+
+// width returns the number of bytes occupied by the field in each row
+func (c column) width(binary bool) int {
+	if binary {
+		return binarySize(c.code, c.repeat)
+	}
+	return c.repeat
+}
+
+// copyKeys returns a copy of keys
+// The values stored in Keys are immutable (numbers, strings, bools or nil), so a shallow copy is enough
+func copyKeys(keys map[string]interface{}) map[string]interface{} {
+	p := make(map[string]interface{}, len(keys))
+	for key, value := range keys {
+		p[key] = value
+	}
+	return p
+}
+
+// Clone returns a copy of h with its own Keys, Naxis, BlankCards, History, Comments and Warnings, so the header of the copy can be modified
+// without affecting h. Data (and the heap of a binary table) is shared with h: Clone is cheap, but a change to the pixels
+// or rows through one unit is visible through the other. Use CloneWithData for an independent copy of Data
+// The accessor functions (At, IntAt, FloatAt, Blank and the table fields) are rebuilt to refer to the copy. If the fields
+// cannot be rebuilt (e.g. TFORMn has been changed to an invalid value in h.Keys), a Warning is added to the copy
+// The header as read from the file is not kept, since the keys of the copy may change: HeaderBlocks and VerifyChecksum
+// of the copy are based on its keys (as encoded by Write)
+func (h *Unit) Clone() *Unit {
+	return h.clone(false)
+}
+
+// CloneWithData is similar to Clone, but Data (and the heap) is copied as well, so the copy is fully independent of h
+func (h *Unit) CloneWithData() *Unit {
+	return h.clone(true)
+}
+
+// clone is the helper function for Clone and CloneWithData
+func (h *Unit) clone(data bool) *Unit {
+	g := &Unit{
+		Keys:       copyKeys(h.Keys),
+		Naxis:      append([]int(nil), h.Naxis...),
+		Data:       h.Data,
+		class:      h.class,
+		blank:      h.blank,
+		At:         h.At,
+		IntAt:      h.IntAt,
+		FloatAt:    h.FloatAt,
+		Blank:      h.Blank,
+		BlankCards: append([]string(nil), h.BlankCards...),
+		History:    append([]string(nil), h.History...),
+		Comments:   append([]string(nil), h.Comments...),
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+		defaults:   copyKeys(h.defaults),
+		heap:       h.heap,
+		dups:       append([]string(nil), h.dups...),
+		parent:     h.parent,
+	}
+	if data {
+		if h.Data != nil {
+			src := reflect.ValueOf(h.Data)
+			dst := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+			reflect.Copy(dst, src)
+			g.Data = dst.Interface()
+		}
+		if h.heap != nil {
+			g.heap = append([]byte(nil), h.heap...)
+		}
+	}
+
+	var err error
+	switch {
+	case h.HasTable() && g.Data == nil: // the data is not loaded (see OpenHeaders), so there are no fields to rebuild
+		g.setNoData()
+	case h.HasTable():
+		err = g.buildTable(h.class != "TABLE") // fails only if the keys of h have changed since its fields were built
+	case len(g.Naxis) > 0 && product(g.Naxis) > 0 && g.Data != nil:
+		g.setAccessors()
+	} // otherwise, the accessor functions of h do not refer to h or its data and are shared
+	g.Warnings = append([]Warning(nil), h.Warnings...) // replaces the warnings repeated by buildTable or setAccessors
+	if err != nil {
+		g.warn("", "the fields of the copy are incomplete: %v", err)
+	}
+	return g
+}
+
+// binaryForm parses TFORM of a binary table field, which is in the form of rT (r is the repeat and T is the type code)
+// It returns the type code and the repeat count (1 if r is missing)
+// Lowercase type codes (e.g. 5e), which are emitted by some non-conforming writers, are accepted and returned in uppercase
+func binaryForm(form string) (code byte, repeat int, err error) {
+	j := strings.IndexFunc(form, func(c rune) bool { return c != ' ' && (c < '0' || c > '9') }) // the type code follows the repeat
+	if j == -1 || !strings.ContainsRune("ABCDEIJKLMPQX", unicode.ToUpper(rune(form[j]))) {
+		return 0, 0, ErrUnsupportedForm{form}
+	}
+	repeat = 1
+	if prefix := strings.TrimSpace(form[:j]); prefix != "" { // some writers add spaces before the repeat count
+		r, err := strconv.ParseInt(prefix, 10, 32)
+		if err != nil || r < 0 {
+			return 0, 0, ErrUnsupportedForm{form} // invalid repeat count
+		}
+		repeat = int(r)
+	}
+	return byte(unicode.ToUpper(rune(form[j]))), repeat, nil
+}
+
+// varElem returns the type code of the elements of a variable length array field from its TFORM (rPt or rQt, e.g. 'B' for 1PB(200))
+func varElem(form string) (byte, error) {
+	up := strings.ToUpper(form) // accept lowercase type codes as in binaryForm
+	j := strings.IndexAny(up, "PQ")
+	if j == -1 || j+1 >= len(up) || !strings.ContainsRune("ABCDEIJKLMX", rune(up[j+1])) {
+		return 0, ErrUnsupportedForm{form}
+	}
+	return up[j+1], nil
+}
+
+// binarySize returns the number of bytes occupied by a binary table field with the given type code and repeat count
+func binarySize(code byte, repeat int) int {
+	switch code {
+	case 'A', 'B', 'L':
+		return repeat
+	case 'I':
+		return 2 * repeat
+	case 'J', 'E':
+		return 4 * repeat
+	case 'K', 'D', 'C', 'P':
+		return 8 * repeat
+	case 'M', 'Q':
+		return 16 * repeat
+	case 'X':
+		return (repeat + 7) / 8
+	}
+	return 0
+}
+
 // NewReader generates a new fits.Reader that wraps the given reader
 // 2880 is the standard FITS file block size 
 func NewReader(reader io.Reader) *Reader {
@@ -952,8 +2448,11 @@ func (b *Reader) Read(p []byte) (n int, err error) {
 		if n == m {
 			return n, nil
 		}
-		b.right, err = b.reader.Read(b.buf)
+		b.right, err = io.ReadFull(b.reader, b.buf) // a whole block is read even if the underlying reader returns short reads
 		b.left = 0
+		if err == io.ErrUnexpectedEOF { // the last block is incomplete, but its data is still valid; EOF is reported by the next call
+			err = nil
+		}
 		if err != nil {
 			if err == io.EOF {
 				b.eof = true
@@ -965,14 +2464,77 @@ func (b *Reader) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("unreachable!")
 }
 
+// offset returns the position of the next byte to be read by b in the memory-mapped file (only valid if b.mapped is set)
+func (b *Reader) offset() int {
+	r, ok := b.reader.(*bytes.Reader)
+	if !ok {
+		return 0
+	}
+	return int(r.Size()) - r.Len() - (b.right - b.left)
+}
+
+// skip advances b by n bytes without copying them into the caller's buffers
+// If the underlying reader is an io.Seeker (e.g. the bytes.Reader used by OpenMmap or an os.File), the whole blocks are skipped
+// by seeking, so the corresponding pages of a memory-mapped file are not touched; otherwise, or if seeking fails (e.g. os.Stdin
+// connected to a pipe), they are read and discarded. ErrTruncated is returned if the input ends before n bytes
+func (b *Reader) skip(n int) error {
+	size := n
+	k := b.right - b.left
+	if k > n {
+		k = n
+	}
+	b.left += k
+	n -= k
+	if n == 0 {
+		return nil
+	}
+	whole := n / 2880 * 2880
+	b.left, b.right = 0, 0
+	if pos, end, ok := seekForward(b.reader, int64(whole)); !ok {
+		m, _ := io.CopyN(ioutil.Discard, b.reader, int64(whole))
+		if m < int64(whole) {
+			b.eof = true
+			return ErrTruncated{size, k + int(m)}
+		}
+	} else if pos > end {
+		b.eof = true
+		return ErrTruncated{size, k + whole - int(pos-end)}
+	}
+	if m, _ := b.Read(make([]byte, n-whole)); m < n-whole {
+		return ErrTruncated{size, k + whole + m}
+	}
+	return nil
+}
+
+// seekForward advances r by n bytes if r is an io.Seeker and returns the new position and the size of r (end is pos if the
+// size cannot be found). ok is false if r is not an io.Seeker or seeking fails, in which case the position of r is unchanged
+func seekForward(r io.Reader, n int64) (pos int64, end int64, ok bool) {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return 0, 0, false
+	}
+	pos, err := s.Seek(n, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = pos
+	if x, err := s.Seek(0, io.SeekEnd); err == nil { // seeking past the end of a file is not an error, so the size is checked
+		if _, err := s.Seek(pos, io.SeekStart); err == nil {
+			end = x
+		}
+	}
+	return pos, end, true
+}
+
 // IsEOF returns if b is finished
 func (b *Reader) IsEOF() bool {
 	return b.eof
 }
 
 // NextPage skips the rest of the current 2880-byte block and reads the next block
+// An error is returned if the file ends before the end of the block (io.ErrUnexpectedEOF) or there is no more block (io.EOF)
 func (b *Reader) NextPage() (buf []byte, err error) {
-	b.right, err = b.reader.Read(b.buf)
+	b.right, err = io.ReadFull(b.reader, b.buf)
 	b.left = b.right
 	return b.buf, err
 }
@@ -982,9 +2544,11 @@ func (b *Reader) ReadByte() byte {
 	return b.elem[0]
 }
 
+// ReadBool reads a logical value as stored in binary tables
+// Only 'T' is true; 'F' and the undefined values (0 or a space) are false
 func (b *Reader) ReadBool() bool {
 	b.Read(b.elem[0:1])
-	return b.elem[0] != 0
+	return b.elem[0] == 'T'
 }
 
 func (b *Reader) ReadString(n int) string {
@@ -996,40 +2560,33 @@ func (b *Reader) ReadString(n int) string {
 // ReadInt16 reads an int16 encoded in big-endian binary
 // Note that the FITS standard supports only big-endian binaries
 func (b *Reader) ReadInt16() int16 {
-	b.Read(b.elem[0:2]) // we need to copy into an elem buf instead of pointing directly to b.buf because 
+	b.Read(b.elem[0:2]) // we need to copy into an elem buf instead of pointing directly to b.buf because
 	// the target value may straddle a block boundary
-	x := uint16(b.elem[1]) | uint16(b.elem[0])<<8
-	return int16(x)
+	return int16(binary.BigEndian.Uint16(b.elem))
 }
 
-// ReadInt16 reads an int32 encoded in big-endian binary
+// ReadInt32 reads an int32 encoded in big-endian binary
 func (b *Reader) ReadInt32() int32 {
 	b.Read(b.elem[0:4])
-	x := uint32(b.elem[3]) | uint32(b.elem[2])<<8 | uint32(b.elem[1])<<16 | uint32(b.elem[0])<<24
-	return int32(x)
+	return int32(binary.BigEndian.Uint32(b.elem))
 }
 
-// ReadInt16 reads an int64 encoded in big-endian binary
+// ReadInt64 reads an int64 encoded in big-endian binary
 func (b *Reader) ReadInt64() int64 {
 	b.Read(b.elem[0:8])
-	x := uint64(b.elem[7]) | uint64(b.elem[6])<<8 | uint64(b.elem[5])<<16 | uint64(b.elem[4])<<24 |
-		uint64(b.elem[3])<<32 | uint64(b.elem[2])<<40 | uint64(b.elem[1])<<48 | uint64(b.elem[0])<<56
-	return int64(x)
+	return int64(binary.BigEndian.Uint64(b.elem))
 }
 
-// ReadInt16 reads a float32 encoded in big-endian binary
+// ReadFloat32 reads a float32 encoded in big-endian binary
 func (b *Reader) ReadFloat32() float32 {
 	b.Read(b.elem[0:4])
-	x := uint32(b.elem[3]) | uint32(b.elem[2])<<8 | uint32(b.elem[1])<<16 | uint32(b.elem[0])<<24
-	return math.Float32frombits(x)
+	return math.Float32frombits(binary.BigEndian.Uint32(b.elem))
 }
 
-// ReadInt16 reads a float64 encoded in big-endian binary
+// ReadFloat64 reads a float64 encoded in big-endian binary
 func (b *Reader) ReadFloat64() float64 {
 	b.Read(b.elem[0:8])
-	x := uint64(b.elem[7]) | uint64(b.elem[6])<<8 | uint64(b.elem[5])<<16 | uint64(b.elem[4])<<24 |
-		uint64(b.elem[3])<<32 | uint64(b.elem[2])<<40 | uint64(b.elem[1])<<48 | uint64(b.elem[0])<<56
-	return math.Float64frombits(x)
+	return math.Float64frombits(binary.BigEndian.Uint64(b.elem))
 }
 
 // Nth returns a string resulted from concatenation of prefix and n in string form
@@ -1038,6 +2595,138 @@ func Nth(prefix string, n int) string {
 	return fmt.Sprintf("%s%d", prefix, n)
 }
 
+// isHeaderStart returns true if buf starts with a SIMPLE or XTENSION card, i.e. it can be the first block of a header
+func isHeaderStart(buf []byte) bool {
+	if len(buf) < 10 {
+		return false
+	}
+	s := string(buf[:10])
+	return s == "SIMPLE  = " || s == "XTENSION= "
+}
+
+// resync finds the start of the next header in the resync mode and returns its first block
+// The header may start at any card boundary (a multiple of 80 bytes from the start of a block), either in the unread part
+// of the current block (e.g. if the previous data unit was not padded) or in one of the following blocks; the input is then
+// realigned so that the header starts a block. A warning is added to h if the header is not where it should have been
+func (b *Reader) resync(h *Unit) (buf []byte, err error) {
+	buf = b.buf[:b.right]
+	i := (b.left + 79) / 80 * 80 // the first card boundary in the unread part of the current block
+	fresh := false               // whether buf is a new block rather than the rest of the current one
+	skipped := 0
+	for {
+		for ; i+80 <= len(buf); i += 80 {
+			if !isHeaderStart(buf[i:]) {
+				continue
+			}
+			if i > 0 { // realign the block to the header
+				n := copy(b.buf, buf[i:])
+				if _, err := io.ReadFull(b.reader, b.buf[n:]); err != nil {
+					return b.buf, err
+				}
+				b.left, b.right = len(b.buf), len(b.buf)
+			}
+			if fresh {
+				skipped += i
+			}
+			if skipped > 0 {
+				h.warn("", "skipped %d bytes to find the start of the header", skipped)
+			} else if i > 0 {
+				h.warn("", "the header starts at byte %d of the last block of the previous unit", i)
+			}
+			return b.buf, nil
+		}
+		if fresh {
+			skipped += len(buf)
+		}
+		if buf, err = b.NextPage(); err != nil {
+			return buf, err
+		}
+		fresh = true
+		i = 0
+	}
+}
+
+// Warning describes a non-fatal problem found while reading a header, e.g. a value that could not be parsed
+// Such values are either dropped or replaced with zero in Keys
+type Warning struct {
+	Key string // The keyword of the card causing the warning
+	Msg string // A human-readable description, e.g. "could not parse value of keyword FOO: ..."
+}
+
+func (w Warning) String() string {
+	return w.Msg
+}
+
+// warn adds a Warning to h.Warnings
+func (h *Unit) warn(key string, format string, a ...interface{}) {
+	h.Warnings = append(h.Warnings, Warning{key, fmt.Sprintf(format, a...)})
+}
+
+// repeatable is the set of the keywords that may legally appear more than once in a header
+var repeatable = map[string]bool{"COMMENT": true, "HISTORY": true, "CONTINUE": true}
+
+// Duplicates returns the list of the keywords that appear more than once in the header of h, in the order of their first
+// repetition. The keywords that may legally repeat (COMMENT, HISTORY and CONTINUE) are excluded
+// For duplicate keys, Keys holds the last value found in the header
+func (h *Unit) Duplicates() []string {
+	return append([]string(nil), h.dups...)
+}
+
+// isDuplicate returns true if key is already in h.dups
+func (h *Unit) isDuplicate(key string) bool {
+	for _, k := range h.dups {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNumber is utilized by NewHeader to parse numerical values in the header
+// Values with a decimal point or an exponent (E or D) are returned as float64 and the rest as int, except for a negative
+// zero without a decimal point (e.g. -0), which is returned as float64 to preserve its sign. Note that this changes the type
+// of such a key from int to float64, so Keys[key].(int) fails for it (floatKey accepts both types)
+// Integers are parsed as 64-bit values; those that do not fit in an int (e.g. TZERO = 9223372036854775808, which marks
+// uint64 table fields) are returned as float64
+// On error, the zero value of the corresponding type is returned
+func parseNumber(value string) (interface{}, error) {
+	if strings.ContainsAny(value, ".DE") {
+		value = strings.Replace(value, "D", "E", 1) // converts D type floats to E type
+		x, err := strconv.ParseFloat(value, 64)
+		return x, err
+	}
+	x, err := strconv.ParseInt(value, 10, 64)
+	if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	if x == 0 && value[0] == '-' {
+		return math.Copysign(0, -1), nil
+	}
+	if int64(int(x)) != x { // int is 32-bit
+		return float64(x), nil
+	}
+	return int(x), nil
+}
+
+// specialFloat recognizes the textual values NaN, Inf and Infinity (case-insensitive and optionally signed) that some
+// software writes for floating point keys, although the standard has no representation for them
+func specialFloat(value string) (float64, bool) {
+	switch strings.ToUpper(strings.TrimLeft(value, "+-")) {
+	case "NAN":
+		return math.NaN(), true
+	case "INF", "INFINITY":
+		if value[0] == '-' {
+			return math.Inf(-1), true
+		}
+		return math.Inf(1), true
+	}
+	return 0, false
+}
+
 // processString is utilized by NewHeader to process string-type values in the header
 // it uses a 3-state machine to process double single quotes
 func processString(s string) (string, error) {
@@ -1068,32 +2757,91 @@ func processString(s string) (string, error) {
 			}
 		}
 	}
+	if state == 2 { // the closing quote is the last character (no comment follows the value)
+		return strings.TrimRight(buf.String(), " "), nil
+	}
 	return "", fmt.Errorf("String ends prematurely")
 }
 
 // NewHeader reads and processes the next header from the a the reader stream
 // its main function is to populate Keys and setups Naxis
+// The keys of HIERARCH cards are stored with the HIERARCH prefix and the words separated by single spaces
+// (e.g. "HIERARCH ESO DET CHIP ID"). String values continued over CONTINUE cards (the long-string convention, the value
+// ends with '&') are joined without the '&' markers, for both standard and HIERARCH keys
+// The comment of a numeric value starts at the first '/', even without a preceding space: for a malformed card such as
+// FOO = 12/34 / comment, the value before the '/' is kept (12) and a Warning is added
+// The non-standard values NaN, Inf and Infinity (case-insensitive, optionally signed) are stored as float64 with a Warning,
+// and an integer negative zero (-0) is stored as float64 (not int) to preserve its sign (see parseNumber)
 func (b *Reader) NewHeader() (h *Unit, err error) {
 	Keys := make(map[string]interface{}, 50)
 	h = &Unit{Keys: Keys}
 
+	cont := "" // the key whose string value ends with '&' and may be continued by CONTINUE cards
 	for {
-		buf, err := b.NextPage()
+		var buf []byte
+		if len(h.raw) == 0 && b.opts.Resync {
+			buf, err = b.resync(h)
+		} else {
+			buf, err = b.NextPage()
+		}
 		if err != nil {
-			fmt.Println(err)
 			return h, err
 		}
+		h.raw = append(h.raw, buf...)
 
 	_lines:
 		for i := 0; i < 36; i++ { // each FITS header block is comprised of up to 36 80-byte lines
 			s := string(buf[i*80 : (i+1)*80])
 			key := strings.TrimSpace(s[:8])
-			if s[8:10] != "= " { // note that the standard is strict regarding the position of the '=' sign
+			if key == "CONTINUE" && cont != "" { // the long-string convention: CONTINUE  'rest of the string&'
+				if x, err := processString(strings.TrimSpace(s[8:])); err == nil {
+					Keys[cont] = strings.TrimSuffix(Keys[cont].(string), "&") + x
+					if !strings.HasSuffix(x, "&") {
+						cont = ""
+					}
+					continue
+				}
+			}
+			cont = ""
+
+			eq := 8 // the position of the '=' sign
+
+			if key == "HIERARCH" { // the ESO convention: HIERARCH ESO DET CHIP ID = value / comment
+				if j := strings.Index(s, "="); j != -1 {
+					key = "HIERARCH " + strings.Join(strings.Fields(s[8:j]), " ")
+					eq = j
+				}
+			}
+
+			if key == "" { // blank keyword, the rest of the card is commentary
+				h.BlankCards = append(h.BlankCards, s[8:])
+				continue
+			}
+			if key == "END" { // the rest of the block is padding and is ignored
+				Keys[key] = nil
+				h.order = append(h.order, key)
+				break _lines
+			}
+			if _, seen := Keys[key]; !seen {
+				h.order = append(h.order, key)
+			} else if !repeatable[key] && !h.isDuplicate(key) {
+				h.dups = append(h.dups, key)
+				if b.opts.WarnDuplicates {
+					h.warn(key, "duplicate keyword %v, the last value is used", key)
+				}
+			}
+			if key == "HISTORY" {
+				h.History = append(h.History, strings.TrimRight(s[8:], " "))
+			}
+			if key == "COMMENT" {
+				h.Comments = append(h.Comments, strings.TrimRight(s[8:], " "))
+			}
+			if eq == 8 && s[8:10] != "= " { // note that the standard is strict regarding the position of the '=' sign
 				Keys[key] = nil
 				continue
 			}
 
-			s = strings.TrimSpace(s[10:])
+			s = strings.TrimSpace(s[eq+1:])
 
 			if s == "" {
 				Keys[key] = nil
@@ -1106,16 +2854,28 @@ func (b *Reader) NewHeader() (h *Unit, err error) {
 				s, err := processString(s) // processes string type values
 				if err == nil {
 					Keys[key] = s
+					if strings.HasSuffix(s, "&") {
+						cont = key
+					}
+				} else {
+					h.warn(key, "could not parse string value: %v", err)
 				}
 				continue _lines
 			}
 
+			full := "" // the value up to a later comment separator " /", used in the warning for malformed numeric values
+			if j := strings.Index(s, " /"); j != -1 {
+				full = strings.TrimSpace(s[:j])
+			}
 			j := strings.Index(s, "/")
 			if j != -1 {
 				s = s[:j]
 			}
 
 			value := strings.TrimSpace(s)
+			if full == "" {
+				full = value
+			}
 
 			if value == "" { // we repeat this to take into account for empty values that have comments
 				// we could not remove comments before processString because / is valid in a string value
@@ -1123,23 +2883,37 @@ func (b *Reader) NewHeader() (h *Unit, err error) {
 				continue
 			}
 
+			if x, ok := specialFloat(value); ok { // not allowed by the standard, but written by some software
+				h.warn(key, "non-standard value of keyword %v: %v is read as %v", key, value, x)
+				Keys[key] = x
+				if h.tokens == nil {
+					h.tokens = make(map[string]string)
+				}
+				h.tokens[key] = value // is written back as is, as formatFloat cannot represent x
+				continue
+			}
+
 			if (first >= '0' && first <= '9') || first == '+' || first == '-' {
-				if strings.ContainsAny(value, ".DE") {
-					value = strings.Replace(value, "D", "E", 1) // converts D type floats to E type
-					x, _ := strconv.ParseFloat(value, 64)
-					Keys[key] = x
-				} else {
-					x, _ := strconv.ParseInt(value, 10, 32)
-					Keys[key] = int(x)
+				x, err := parseNumber(value)
+				if err == nil && full != value { // a '/' inside the value (e.g. 12/34 / comment), the part before it is kept
+					h.warn(key, "value of keyword %v is followed by '/' without a space, %v is used instead of %v", key, value, full)
 				}
+				if err != nil {
+					h.warn(key, "could not parse value of keyword %v: %v", key, err)
+				}
+				Keys[key] = x
 			} else if first == 'T' {
 				Keys[key] = true
 			} else if first == 'F' {
 				Keys[key] = false
 			} else if first == '(' {
 				var x, y float64
-				fmt.Sscanf(value, "(%f,%f)", &x, &y)
+				if _, err := fmt.Sscanf(value, "(%f,%f)", &x, &y); err != nil {
+					h.warn(key, "could not parse value of keyword %v: %v", key, err)
+				}
 				Keys[key] = complex(x, y)
+			} else {
+				h.warn(key, "unrecognized value of keyword %v: %v", key, value)
 			}
 		}
 		_, ends := Keys["END"]
@@ -0,0 +1,601 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// card returns a fixed-format 80-byte card with a value and an empty comment
+func card(key string, value string) string {
+	return fmt.Sprintf("%-80s", fmt.Sprintf("%-8s= %-20s /", key, value))
+}
+
+// rawCard pads s to a full 80-byte card
+func rawCard(s string) string {
+	return fmt.Sprintf("%-80s", s)
+}
+
+// header returns the cards followed by END, padded with spaces to a block boundary
+func header(cards ...string) []byte {
+	var b bytes.Buffer
+	for _, c := range cards {
+		b.WriteString(c)
+	}
+	b.WriteString(rawCard("END"))
+	for b.Len()%2880 != 0 {
+		b.WriteByte(' ')
+	}
+	return b.Bytes()
+}
+
+// pad pads a data unit with zeros to a block boundary
+func pad(data []byte) []byte {
+	for len(data)%2880 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// emptyPrimary is a primary header with no data
+func emptyPrimary() []byte {
+	return header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"))
+}
+
+// binTable returns a file made of an empty primary and a binary table with the given
+// cards (after XTENSION, BITPIX and NAXIS) and row bytes
+func binTable(cards []string, rows []byte) []byte {
+	cards = append([]string{card("XTENSION", "'BINTABLE'"), card("BITPIX", "8"), card("NAXIS", "2")}, cards...)
+	return concat(emptyPrimary(), header(cards...), pad(rows))
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// openBytes opens the FITS file held in data and fails the test on error
+func openBytes(t testing.TB, data []byte) []*Unit {
+	fits, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fits
+}
+
+func int16s(vals ...int16) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = append(b, byte(uint16(v)>>8), byte(v))
+	}
+	return b
+}
+
+func float64s(vals ...float64) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// image16 returns a 2x2 BITPIX=16 image with the given pixels and extra header cards
+func image16(t testing.TB, extra []string, pixels ...int16) *Unit {
+	cards := append([]string{card("SIMPLE", "T"), card("BITPIX", "16"), card("NAXIS", "2"), card("NAXIS1", "2"), card("NAXIS2", "2")}, extra...)
+	return openBytes(t, concat(header(cards...), pad(int16s(pixels...))))[0]
+}
+
+func TestLogicalUndefined(t *testing.T) {
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "1"), card("NAXIS2", "4"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "1"), card("TFORM1", "'L'"), card("TTYPE1", "'OK'")}, []byte{'T', 'F', ' ', 0}))
+	h := fits[1]
+	want := []bool{true, false, false, false}
+	undef := []bool{false, false, true, true}
+	for row := range want {
+		if v := h.Field("OK")(row).(bool); v != want[row] {
+			t.Errorf("row %d: got %v, want %v", row, v, want[row])
+		}
+		if h.IsUndefined("OK", row) != undef[row] || h.IsUndefined(0, row) != undef[row] {
+			t.Errorf("row %d: IsUndefined should be %v", row, undef[row])
+		}
+	}
+}
+
+func TestResyncUnaligned(t *testing.T) {
+	// the 160-byte data unit of the primary is not padded, so the extension starts at the third card of a block
+	data := concat(header(card("SIMPLE", "T"), card("BITPIX", "16"), card("NAXIS", "1"), card("NAXIS1", "80")), int16s(make([]int16, 80)...),
+		header(card("XTENSION", "'IMAGE'"), card("BITPIX", "16"), card("NAXIS", "1"), card("NAXIS1", "2"), card("PCOUNT", "0"), card("GCOUNT", "1")),
+		pad(int16s(3, 4)))
+	fits, err := OpenWith(bytes.NewReader(data), Options{Resync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fits) != 2 || fits[1].FloatAt(0) != 3 || fits[1].FloatAt(1) != 4 {
+		t.Fatalf("the extension was not recovered: %d units", len(fits))
+	}
+	if len(fits[1].Warnings) != 1 {
+		t.Errorf("expected one warning, got %v", fits[1].Warnings)
+	}
+}
+
+func TestOpenHeadersNoData(t *testing.T) {
+	data := concat(header(card("SIMPLE", "T"), card("BITPIX", "16"), card("NAXIS", "2"), card("NAXIS1", "2"), card("NAXIS2", "2")),
+		pad(int16s(1, 2, 3, 4)))
+	fits, err := OpenHeaders(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := fits[0]
+	if h.Data != nil || h.At(1, 1) != nil || h.IntAt(1, 1) != 0 || h.FloatAt(1, 1) != 0 || !h.Blank(1, 1) {
+		t.Error("the accessors of a unit without data should return zero values")
+	}
+	if _, err := h.AtChecked(1, 1); err == nil {
+		t.Error("AtChecked should fail if the data is not loaded")
+	}
+}
+
+func TestRowWidth(t *testing.T) {
+	cards := func(naxis1 string) []string {
+		return []string{card("NAXIS1", naxis1), card("NAXIS2", "2"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+			card("TFIELDS", "2"), card("TFORM1", "'J'"), card("TFORM2", "'I'")}
+	}
+
+	// NAXIS1 is larger than the sum of the field widths (6): the 2 padding bytes at the end of each row are ignored
+	fits := openBytes(t, binTable(cards("8"), []byte{0, 0, 0, 7, 0, 5, 9, 9, 0, 0, 0, 8, 0, 6, 9, 9}))
+	for row, want := range []int16{5, 6} {
+		if v := fits[1].Field(1)(row).(int16); v != want {
+			t.Errorf("row %d: got %d, want %d", row, v, want)
+		}
+	}
+
+	// NAXIS1 is smaller than the sum of the field widths: the fields would overlap the next row
+	if _, err := Open(bytes.NewReader(binTable(cards("5"), make([]byte, 10)))); err == nil {
+		t.Error("a row narrower than its fields should be rejected")
+	}
+}
+
+func TestLoadDataBlockBoundary(t *testing.T) {
+	// 1000 float64 pixels span three blocks; pixels 359 and 360 are the last of the first block and the first of the second
+	vals := make([]float64, 1000)
+	for i := range vals {
+		vals[i] = float64(i) * 1.5
+	}
+	fits := openBytes(t, concat(header(card("SIMPLE", "T"), card("BITPIX", "-64"), card("NAXIS", "1"), card("NAXIS1", "1000")),
+		pad(float64s(vals...))))
+	for i, want := range vals {
+		if x := fits[0].FloatAt(i); x != want {
+			t.Fatalf("pixel %d: got %v, want %v", i, x, want)
+		}
+	}
+}
+
+func TestTableBlockBoundary(t *testing.T) {
+	// with 7-byte rows, the J field of row 411 occupies bytes 2877 to 2880 of the data and straddles the block boundary
+	var rows []byte
+	for i := 0; i < 500; i++ {
+		rows = binary.BigEndian.AppendUint32(rows, uint32(100000+i))
+		rows = append(rows, int16s(int16(-i))...)
+		rows = append(rows, byte(i))
+	}
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "7"), card("NAXIS2", "500"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "3"), card("TFORM1", "'J'"), card("TFORM2", "'I'"), card("TFORM3", "'B'")}, rows))
+	for _, row := range []int{410, 411, 412} {
+		if x := fits[1].Field(0)(row).(int32); x != int32(100000+row) {
+			t.Errorf("row %d: got %d, want %d", row, x, 100000+row)
+		}
+		if x := fits[1].Field(1)(row).(int16); x != int16(-row) {
+			t.Errorf("row %d: got %d, want %d", row, x, -row)
+		}
+	}
+}
+
+// float32Image returns a 4096x4096 BITPIX=-32 image used by the loading benchmarks
+func float32Image() []byte {
+	return concat(header(card("SIMPLE", "T"), card("BITPIX", "-32"), card("NAXIS", "2"), card("NAXIS1", "4096"), card("NAXIS2", "4096")),
+		pad(make([]byte, 4096*4096*4)))
+}
+
+func BenchmarkOpenFloat32(b *testing.B) {
+	data := float32Image()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Open(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadFloat32Pixels reads the same image pixel by pixel with Reader.ReadFloat32 for comparison
+func BenchmarkReadFloat32Pixels(b *testing.B) {
+	data := float32Image()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(data))
+		r.NextPage()
+		data := make([]float32, 4096*4096)
+		for k := range data {
+			data[k] = r.ReadFloat32()
+		}
+	}
+}
+
+func TestEmptyAxis(t *testing.T) {
+	// a primary with NAXIS1=0 (and no GROUPS=T) is an empty image, not random groups, so the extensions are read
+	data := concat(header(card("SIMPLE", "T"), card("BITPIX", "16"), card("NAXIS", "2"), card("NAXIS1", "0"), card("NAXIS2", "10")),
+		header(card("XTENSION", "'IMAGE'"), card("BITPIX", "16"), card("NAXIS", "2"), card("NAXIS1", "0"), card("NAXIS2", "10"),
+			card("PCOUNT", "0"), card("GCOUNT", "1")),
+		header(card("XTENSION", "'IMAGE'"), card("BITPIX", "16"), card("NAXIS", "1"), card("NAXIS1", "2"), card("PCOUNT", "0"), card("GCOUNT", "1")),
+		pad(int16s(7, 8)))
+	fits := openBytes(t, data)
+	if len(fits) != 3 {
+		t.Fatalf("got %d units, want 3", len(fits))
+	}
+	for _, h := range fits[:2] {
+		if x, ok := h.Data.([]int16); !ok || len(x) != 0 {
+			t.Errorf("Data should be an empty []int16, got %#v", h.Data)
+		}
+		if h.At(0, 0) != nil || h.FloatAt(0, 0) != 0 || !h.Blank(0, 0) {
+			t.Error("the accessors of an empty image should return zero values")
+		}
+	}
+	if x := fits[2].FloatAt(1); x != 8 {
+		t.Errorf("got %v, want 8", x)
+	}
+}
+
+// foreignFile returns a file with an empty primary, a FOREIGN extension with 6010 bytes of data (which is skipped by Open)
+// and an IMAGE extension holding 3 and 4
+func foreignFile() []byte {
+	return concat(emptyPrimary(),
+		header(card("XTENSION", "'FOREIGN'"), card("BITPIX", "8"), card("NAXIS", "1"), card("NAXIS1", "10"), card("PCOUNT", "6000"), card("GCOUNT", "1")),
+		pad(make([]byte, 6010)),
+		header(card("XTENSION", "'IMAGE'"), card("BITPIX", "16"), card("NAXIS", "1"), card("NAXIS1", "2"), card("PCOUNT", "0"), card("GCOUNT", "1")),
+		pad(int16s(3, 4)))
+}
+
+func TestSkipPipe(t *testing.T) {
+	// a pipe is an *os.File, but seeking it fails, so the data is read and discarded instead
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(foreignFile())
+		w.Close()
+	}()
+	fits, err := Open(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fits) != 3 || fits[2].IntAt(1) != 4 {
+		t.Errorf("the image after the FOREIGN extension was not read correctly (%d units)", len(fits))
+	}
+}
+
+func TestSkipTruncated(t *testing.T) {
+	data := foreignFile()[:2*2880+4000] // the file ends inside the data of the FOREIGN extension
+	for _, r := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+		_, err := Open(r)
+		var truncated ErrTruncated
+		if !errors.As(err, &truncated) || truncated.Size != 6010 || truncated.Read != 4000 {
+			t.Errorf("got %v, want ErrTruncated", err)
+		}
+	}
+}
+
+func TestHierarchContinue(t *testing.T) {
+	long := strings.Repeat("0123456789", 20)
+	fits := openBytes(t, header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"),
+		rawCard("HIERARCH ESO DET  CHIP ID = 'CCD1' / chip"),
+		rawCard("HIERARCH A='"+long[:65]+"&'"),
+		rawCard("CONTINUE  '"+long[65:132]+"&'"),
+		rawCard("CONTINUE  '"+long[132:]+"'"),
+		rawCard("LONGSTRN= 'abc&'"), rawCard("CONTINUE  'def'")))
+	keys := fits[0].Keys
+	if keys["HIERARCH A"] != long {
+		t.Errorf("got %q, want %q", keys["HIERARCH A"], long)
+	}
+	if keys["HIERARCH ESO DET CHIP ID"] != "CCD1" || keys["LONGSTRN"] != "abcdef" {
+		t.Errorf("got %q and %q", keys["HIERARCH ESO DET CHIP ID"], keys["LONGSTRN"])
+	}
+}
+
+func TestSlashInValue(t *testing.T) {
+	fits := openBytes(t, header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"),
+		rawCard("FOO     = 12/34 / comment"), rawCard("BAR     = 12 / comment"), rawCard("BAZ     = 1.5/comment")))
+	h := fits[0]
+	if h.Keys["FOO"] != 12 || h.Keys["BAR"] != 12 || h.Keys["BAZ"] != 1.5 {
+		t.Errorf("got FOO=%v, BAR=%v, BAZ=%v", h.Keys["FOO"], h.Keys["BAR"], h.Keys["BAZ"])
+	}
+	if len(h.Warnings) != 1 || h.Warnings[0].Key != "FOO" {
+		t.Errorf("expected a warning for FOO, got %v", h.Warnings)
+	}
+}
+
+func TestCloneHeader(t *testing.T) {
+	h := image16(t, []string{card("OBJECT", "'M31'")}, 1, 2, 3, 4)
+	if err := h.UpdateChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Unit{h}); err != nil {
+		t.Fatal(err)
+	}
+	h = openBytes(t, buf.Bytes())[0]
+
+	c := h.Clone()
+	if status := c.VerifyChecksum(); !status.ChecksumOK {
+		t.Error("the checksum of an unchanged clone should be valid")
+	}
+	c.Keys["OBJECT"] = "M33"
+	if status := c.VerifyChecksum(); status.ChecksumOK {
+		t.Error("the checksum of a clone with changed keys should be invalid")
+	}
+	if status := h.VerifyChecksum(); !status.ChecksumOK {
+		t.Error("the checksum of the original should still be valid")
+	}
+	for i := 0; i < 40; i++ {
+		c.Keys[fmt.Sprintf("KEY%d", i)] = i
+	}
+	if c.HeaderBlocks() != 2 || h.HeaderBlocks() != 1 {
+		t.Errorf("got %d and %d header blocks, want 2 and 1", c.HeaderBlocks(), h.HeaderBlocks())
+	}
+}
+
+func TestTextFormWithoutWidth(t *testing.T) {
+	// the width of A (and the other TFORMs of text tables) is mandatory
+	for _, form := range []string{"'A'", "'I'"} {
+		data := concat(emptyPrimary(),
+			header(card("XTENSION", "'TABLE'"), card("BITPIX", "8"), card("NAXIS", "2"), card("NAXIS1", "7"), card("NAXIS2", "1"),
+				card("PCOUNT", "0"), card("GCOUNT", "1"), card("TFIELDS", "1"), card("TFORM1", form), card("TBCOL1", "1")),
+			pad([]byte("abcdefg")))
+		_, err := Open(bytes.NewReader(data))
+		var unsupported ErrUnsupportedForm
+		if !errors.As(err, &unsupported) {
+			t.Errorf("TFORM1=%s: got %v, want ErrUnsupportedForm", form, err)
+		}
+	}
+}
+
+func TestWideTextInt(t *testing.T) {
+	fits := openBytes(t, concat(emptyPrimary(),
+		header(card("XTENSION", "'TABLE'"), card("BITPIX", "8"), card("NAXIS", "2"), card("NAXIS1", "23"), card("NAXIS2", "2"),
+			card("PCOUNT", "0"), card("GCOUNT", "1"), card("TFIELDS", "2"),
+			card("TFORM1", "'I18'"), card("TBCOL1", "1"), card("TTYPE1", "'ID'"), card("TFORM2", "'I5'"), card("TBCOL2", "19"), card("TTYPE2", "'N'")),
+		pad([]byte(fmt.Sprintf("%18d%5d%18d%5d", int64(123456789012), 42, int64(-3000000000), -7)))))
+	h := fits[1]
+	for row, want := range []int64{123456789012, -3000000000} { // both beyond the range of int32
+		if x := h.Field("ID")(row); x != want {
+			t.Errorf("row %d: got %#v, want int64(%d)", row, x, want)
+		}
+	}
+	if x := h.Field("N")(1); x != -7 {
+		t.Errorf("got %#v, want -7", x)
+	}
+}
+
+func TestSpecialFloats(t *testing.T) {
+	fits := openBytes(t, header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"),
+		card("NZERO", "-0"), card("NZEROF", "-0.0"), card("ZERO", "0"), card("NAN", "NaN"), card("NINF", "-inf"), card("PINF", "+Infinity"),
+		card("INF", "INF")))
+	keys := fits[0].Keys
+	for _, key := range []string{"NZERO", "NZEROF"} {
+		if x, ok := keys[key].(float64); !ok || x != 0 || !math.Signbit(x) {
+			t.Errorf("%s: got %#v, want float64(-0)", key, keys[key])
+		}
+	}
+	if keys["ZERO"] != 0 {
+		t.Errorf("ZERO: got %#v, want int(0)", keys["ZERO"])
+	}
+	if x, ok := keys["NAN"].(float64); !ok || !math.IsNaN(x) {
+		t.Errorf("NAN: got %#v, want NaN", keys["NAN"])
+	}
+	for key, sign := range map[string]int{"NINF": -1, "PINF": 1, "INF": 1} {
+		if x, ok := keys[key].(float64); !ok || !math.IsInf(x, sign) {
+			t.Errorf("%s: got %#v, want %v", key, keys[key], math.Inf(sign))
+		}
+	}
+	if len(fits[0].Warnings) != 4 { // NaN and the three infinities
+		t.Errorf("expected 4 warnings, got %v", fits[0].Warnings)
+	}
+}
+
+func TestFormatLowercaseTdisp(t *testing.T) {
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "16"), card("NAXIS2", "1"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "2"), card("TFORM1", "'D'"), card("TTYPE1", "'X'"), card("TDISP1", "'f8.2'"),
+		card("TFORM2", "'D'"), card("TTYPE2", "'Y'"), card("TDISP2", "'F8.2'")}, float64s(3.14159, 3.14159)))
+	for _, col := range []string{"X", "Y"} {
+		if s := fits[1].Format(col, 0); s != "    3.14" {
+			t.Errorf("%s: got %q, want %q", col, s, "    3.14")
+		}
+	}
+}
+
+func TestTextTableDExponent(t *testing.T) {
+	rows := []string{"   1.5D+02", "  -2.5d-01", "    3.0E+1", "       12."}
+	fits := openBytes(t, concat(emptyPrimary(),
+		header(card("XTENSION", "'TABLE'"), card("BITPIX", "8"), card("NAXIS", "2"), card("NAXIS1", "10"), card("NAXIS2", "4"),
+			card("PCOUNT", "0"), card("GCOUNT", "1"), card("TFIELDS", "1"),
+			card("TFORM1", "'D10.3'"), card("TBCOL1", "1"), card("TTYPE1", "'X'")),
+		pad([]byte(strings.Join(rows, "")))))
+	for row, want := range []float64{150, -0.25, 30, 12} {
+		if x := fits[1].Field("X")(row); x != want {
+			t.Errorf("row %d (%q): got %#v, want %v", row, rows[row], x, want)
+		}
+	}
+	for s, want := range map[string]float64{"1D3": 1000, " 2.5d-1 ": 0.25, "-4.0E2": -400} {
+		if x, err := parseFortranFloat(s); err != nil || x != want {
+			t.Errorf("parseFortranFloat(%q) = %v, %v, want %v", s, x, err, want)
+		}
+	}
+}
+
+func TestKeepRaw(t *testing.T) {
+	cards := []string{card("SIMPLE", "T"), card("BITPIX", "16"), card("NAXIS", "2"), card("NAXIS1", "2"), card("NAXIS2", "2"),
+		card("BZERO", "32768")}
+	data := concat(header(cards...), pad(int16s(-32768, 0, 1, 2)))
+	for _, opts := range []Options{{}, {KeepRaw: true}} {
+		fits, err := OpenWith(bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x := fits[0].Data.([]int16)[0]; x != -32768 {
+			t.Errorf("%+v: got raw value %d, want -32768", opts, x)
+		}
+		if x := fits[0].ScaledAt(1, 0); x != 32768 {
+			t.Errorf("%+v: got scaled value %v, want 32768", opts, x)
+		}
+	}
+}
+
+func TestStatsByteBlank(t *testing.T) {
+	cards := []string{card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "2"), card("NAXIS1", "2"), card("NAXIS2", "2"),
+		card("BLANK", "255")}
+	h := openBytes(t, concat(header(cards...), pad([]byte{255, 7, 200, 255})))[0]
+	if !h.Blank(0, 0) || h.Blank(1, 0) {
+		t.Errorf("got Blank(0, 0) = %v and Blank(1, 0) = %v, want true and false", h.Blank(0, 0), h.Blank(1, 0))
+	}
+	if min, max := h.Stats(); min != 7 || max != 200 {
+		t.Errorf("got Stats() = %v, %v, want 7, 200", min, max)
+	}
+	if min, _, max, _ := h.StatsLoc(); min != 7 || max != 200 {
+		t.Errorf("got StatsLoc() = %v, %v, want 7, 200", min, max)
+	}
+}
+
+func TestFormatEngineeringScientific(t *testing.T) {
+	// the examples of the EN and ES edit descriptors in the Fortran standard, which the FITS standard refers to
+	// ES always writes the sign of the mantissa
+	values := []float64{0.0217, 12345.678, -0.000312, 3.14159, 0}
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "16"), card("NAXIS2", fmt.Sprint(len(values))), card("PCOUNT", "0"),
+		card("GCOUNT", "1"), card("TFIELDS", "2"), card("TFORM1", "'D'"), card("TTYPE1", "'EN'"), card("TDISP1", "'EN12.3'"),
+		card("TFORM2", "'D'"), card("TTYPE2", "'ES'"), card("TDISP2", "'ES12.3'")},
+		float64s(0.0217, 0.0217, 12345.678, 12345.678, -0.000312, -0.000312, 3.14159, 3.14159, 0, 0)))
+	want := [][2]string{
+		{"  21.700E-03", "  +2.170E-02"},
+		{"  12.346E+03", "  +1.235E+04"},
+		{"-312.000E-06", "  -3.120E-04"},
+		{"   3.142E+00", "  +3.142E+00"},
+		{"   0.000E+00", "  +0.000E+00"},
+	}
+	for row, w := range want {
+		if s := fits[1].Format("EN", row); s != w[0] {
+			t.Errorf("EN12.3, %v: got %q, want %q", values[row], s, w[0])
+		}
+		if s := fits[1].Format("ES", row); s != w[1] {
+			t.Errorf("ES12.3, %v: got %q, want %q", values[row], s, w[1])
+		}
+	}
+}
+
+func TestFormatExponentWidth(t *testing.T) {
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "24"), card("NAXIS2", "1"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "3"), card("TFORM1", "'D'"), card("TTYPE1", "'G'"), card("TDISP1", "'G12.5E3'"),
+		card("TFORM2", "'D'"), card("TTYPE2", "'E'"), card("TDISP2", "'E12.4E3'"),
+		card("TFORM3", "'D'"), card("TTYPE3", "'F'"), card("TDISP3", "'G12.5E3'")}, float64s(1.5e5, -2.5e-7, 12.5)))
+	// G follows %g, which has no exponent for 12.5, and the leading spaces make room for the extra exponent digit
+	for col, want := range map[string]string{"G": "    1.5e+005", "E": "-2.5000e-007", "F": "        12.5"} {
+		if s := fits[1].Format(col, 0); s != want {
+			t.Errorf("%s: got %q, want %q", col, s, want)
+		}
+	}
+}
+
+func TestCloneHeaderOnlyTable(t *testing.T) {
+	data := binTable([]string{card("NAXIS1", "4"), card("NAXIS2", "2"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "1"), card("TFORM1", "'J'"), card("TTYPE1", "'A'")}, make([]byte, 8))
+	fits, err := OpenHeaders(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := fits[1]
+	for _, c := range []*Unit{h.Clone(), h.CloneWithData()} {
+		if c.Data != nil || c.Keys["TTYPE1"] != "A" || len(c.Warnings) != 0 {
+			t.Errorf("got Data = %v, TTYPE1 = %v and warnings %v", c.Data, c.Keys["TTYPE1"], c.Warnings)
+		}
+		if _, err := c.AtChecked(0, 0); err == nil {
+			t.Error("AtChecked should fail if the data is not loaded")
+		}
+	}
+
+	// a table whose keys were changed after its fields were built
+	g := openBytes(t, data)[1]
+	g.Keys["TFORM1"] = "Y"
+	if c := g.Clone(); len(c.Warnings) != 1 {
+		t.Errorf("got warnings %v, want one", c.Warnings)
+	}
+}
+
+func TestWriteSpecialFloats(t *testing.T) {
+	h := openBytes(t, header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"),
+		card("NAN", "NaN"), card("NINF", "-inf"), card("PINF", "+Infinity"), card("CHANGED", "NaN")))[0]
+	h.Keys["CHANGED"] = 1.5
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Unit{h.Clone()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"NAN     =                  NaN", "NINF    =                 -inf", "PINF    =            +Infinity",
+		"CHANGED =                  1.5"} {
+		if !bytes.Contains(buf.Bytes(), []byte(s)) {
+			t.Errorf("%q is not written", s)
+		}
+	}
+	keys := openBytes(t, buf.Bytes())[0].Keys
+	if x, ok := keys["NAN"].(float64); !ok || !math.IsNaN(x) {
+		t.Errorf("NAN: got %#v, want NaN", keys["NAN"])
+	}
+	if !math.IsInf(keys["NINF"].(float64), -1) || !math.IsInf(keys["PINF"].(float64), 1) || keys["CHANGED"] != 1.5 {
+		t.Errorf("got NINF = %v, PINF = %v and CHANGED = %v", keys["NINF"], keys["PINF"], keys["CHANGED"])
+	}
+
+	h.Keys["OTHER"] = math.Inf(1) // a value that was not read from a file cannot be written
+	if err := Write(&buf, []*Unit{h}); err == nil {
+		t.Error("Write should fail for a key set to Inf")
+	}
+}
+
+func TestRenameKeyDerived(t *testing.T) {
+	u := image16(t, []string{card("OBJECT", "'M31'"), card("TELESCOP", "'HST'")}, 1, 2, 3, 4)
+	before := u.OrderedCards()
+	v, err := u.Sub(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RenameKey("OBJECT", "TARGET"); err != nil {
+		t.Fatal(err)
+	}
+	if after := u.OrderedCards(); !reflect.DeepEqual(after, before) {
+		t.Errorf("the cards of the source have changed: got %v, want %v", after, before)
+	}
+
+	tab := openBytes(t, binTable([]string{card("NAXIS1", "4"), card("NAXIS2", "1"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "1"), card("TFORM1", "'J'"), card("TTYPE1", "'A'"), card("OBJECT", "'M31'")}, make([]byte, 4)))[1]
+	before = tab.OrderedCards()
+	g, err := tab.SelectColumns("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.RenameKey("OBJECT", "TARGET"); err != nil {
+		t.Fatal(err)
+	}
+	if after := tab.OrderedCards(); !reflect.DeepEqual(after, before) {
+		t.Errorf("the cards of the source table have changed: got %v, want %v", after, before)
+	}
+}
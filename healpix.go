@@ -0,0 +1,48 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+//
+// This file recognizes the HEALPix all-sky maps stored as binary tables as described in
+//  Gorski K. M. et al. HEALPix: A framework for high-resolution discretization and fast analysis of data distributed on the sphere. ApJ 622, 759 (2005)
+
+package fits
+
+import "strings"
+
+// HealpixInfo describes a HEALPix map as returned by Unit.HealpixInfo
+type HealpixInfo struct {
+	Nside    int    // NSIDE, the resolution parameter (a power of two)
+	Ordering string // ORDERING, the pixel numbering scheme (RING or NESTED)
+	Npix     int    // The number of pixels of the full sphere, 12 * NSIDE^2
+	Explicit bool   // INDXSCHM='EXPLICIT', the pixel indices are stored in a column (e.g. a partial-sky map)
+}
+
+// HealpixInfo returns the HEALPix parameters of h if h is a binary table holding a HEALPix map (PIXTYPE='HEALPIX')
+// ok is false if h is not a HEALPix table or its keys are not valid: NSIDE should be a power of two and ORDERING
+// should be RING or NESTED. For implicitly indexed maps (INDXSCHM is missing or 'IMPLICIT'), the number of
+// elements of the first field (NAXIS2 times its repeat count) should be equal to Npix
+func (h *Unit) HealpixInfo() (info HealpixInfo, ok bool) {
+	pixtype, _ := h.Keys["PIXTYPE"].(string)
+	if h.class != "BINTABLE" || strings.ToUpper(strings.TrimSpace(pixtype)) != "HEALPIX" {
+		return info, false
+	}
+	nside, _ := h.Keys["NSIDE"].(int)
+	if nside <= 0 || nside&(nside-1) != 0 {
+		return info, false
+	}
+	ordering, _ := h.Keys["ORDERING"].(string)
+	ordering = strings.ToUpper(strings.TrimSpace(ordering))
+	if ordering != "RING" && ordering != "NESTED" {
+		return info, false
+	}
+	scheme, _ := h.Keys["INDXSCHM"].(string)
+	info = HealpixInfo{
+		Nside:    nside,
+		Ordering: ordering,
+		Npix:     12 * nside * nside,
+		Explicit: strings.ToUpper(strings.TrimSpace(scheme)) == "EXPLICIT",
+	}
+	if !info.Explicit && (len(h.columns) == 0 || h.Naxis[1]*h.columns[0].repeat != info.Npix) {
+		return info, false
+	}
+	return info, true
+}
@@ -0,0 +1,368 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Sub returns a new image unit equal to h - other (pixelwise), e.g. to subtract a dark frame
+// The result is a float64 image (BITPIX=-64) calculated based on the physical values (after applying BSCALE/BZERO)
+// h and other should have the same dimensions. A result pixel is NaN if the corresponding pixel in either input is blank
+func (h *Unit) Sub(other *Unit) (*Unit, error) {
+	return h.arith(other, func(x, y float64) float64 {
+		return x - y
+	})
+}
+
+// Div returns a new image unit equal to h / other (pixelwise), e.g. to divide by a flat frame
+// It is similar to Sub. In addition, division by zero results in NaN
+func (h *Unit) Div(other *Unit) (*Unit, error) {
+	return h.arith(other, func(x, y float64) float64 {
+		if y == 0 {
+			return math.NaN()
+		}
+		return x / y
+	})
+}
+
+// Plane returns the k'th plane (0-based index along NAXIS3) of a three-dimensional image (a cube) as a new two-dimensional image unit
+// The pixels are copied and keep their original type. The header is copied from h with NAXIS set to 2 and NAXIS3 and
+// the WCS keys of the third axis (e.g. CTYPE3 or CD3_3) removed
+func (h *Unit) Plane(k int) (*Unit, error) {
+	if !h.HasImage() || len(h.Naxis) != 3 {
+		return nil, fmt.Errorf("Plane needs a three-dimensional image")
+	}
+	if k < 0 || k >= h.Naxis[2] {
+		return nil, fmt.Errorf("Plane index %d is out of range [0, %d)", k, h.Naxis[2])
+	}
+
+	naxis := h.Naxis[:2]
+	n := product(naxis)
+	src := reflect.ValueOf(h.Data)
+	data := reflect.MakeSlice(src.Type(), n, n)
+	reflect.Copy(data, src.Slice(k*n, (k+1)*n))
+
+	keys := h.reshapeKeys(naxis)
+	for key := range keys {
+		if isAxisKey(key, 3) {
+			delete(keys, key)
+		}
+	}
+
+	g := &Unit{
+		Keys:       keys,
+		Naxis:      append([]int(nil), naxis...),
+		Data:       data.Interface(),
+		class:      h.class,
+		BlankCards: append([]string(nil), h.BlankCards...),
+		History:    append([]string(nil), h.History...),
+		Comments:   append([]string(nil), h.Comments...),
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+	}
+	g.setAccessors()
+	return g, nil
+}
+
+// LightCurve returns the time series of the pixel at (x, y) of a three-dimensional image whose third axis is time, i.e.
+// the physical value (see ScaledAt) of the pixel in each of the NAXIS3 planes. Blank pixels are returned as NaN
+// An error is returned if h is not a three-dimensional image or (x, y) is outside NAXIS1 x NAXIS2
+func (h *Unit) LightCurve(x, y int) ([]float64, error) {
+	if !h.HasImage() || len(h.Naxis) != 3 {
+		return nil, fmt.Errorf("LightCurve needs a three-dimensional image")
+	}
+	if err := h.checkCoords([]int{x, y, 0}); err != nil {
+		return nil, err
+	}
+	p := make([]float64, h.Naxis[2])
+	for t := range p {
+		p[t] = math.NaN()
+		if !h.Blank(x, y, t) {
+			p[t] = h.ScaledAt(x, y, t)
+		}
+	}
+	return p, nil
+}
+
+// PreviewPixels is the default maximum number of pixels of the image returned by PreviewImage (e.g. 256 x 256)
+const PreviewPixels = 65536
+
+// PreviewImage returns the first image among units (e.g. the result of Open) with at most maxPixels pixels, as a quick-look
+// thumbnail for files that store a small preview besides the full data. maxPixels <= 0 means PreviewPixels
+// The units without image data (e.g. an empty primary HDU) are skipped. ok is false if no image is small enough
+// There is no standard flag for preview images, so the choice is purely based on the size
+func PreviewImage(units []*Unit, maxPixels int) (preview *Unit, ok bool) {
+	if maxPixels <= 0 {
+		maxPixels = PreviewPixels
+	}
+	for _, h := range units {
+		if n := product(h.Naxis); h.HasImage() && h.Data != nil && n > 0 && n <= maxPixels {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+// ComplexAt returns the complex pixel pointed by a... in an image that stores complex values along its last axis
+// (NAXISn=2, where n=NAXIS), i.e. a pixel is the pair of (real, imaginary) values at a..., 0 and a..., 1
+// a... holds NAXIS-1 coordinates. ComplexAt panics if the last axis is not of length 2
+// The layout is a convention rather than part of the standard; IsComplex detects the images marked with CTYPEn='COMPLEX'
+func (h *Unit) ComplexAt(a ...int) complex128 {
+	n := len(h.Naxis)
+	if n == 0 || h.Naxis[n-1] != 2 {
+		panic("fits: ComplexAt needs an image with a last axis of length 2")
+	}
+	b := append(append(make([]int, 0, n), a...), 0)
+	re := h.FloatAt(b...)
+	b[n-1] = 1
+	return complex(re, h.FloatAt(b...))
+}
+
+// IsComplex returns true if h is an image with complex values stored along its last axis (see ComplexAt)
+// The convention is detected based on the CTYPE key of the last axis being equal to 'COMPLEX'
+func (h *Unit) IsComplex() bool {
+	n := len(h.Naxis)
+	if !h.HasImage() || n == 0 || h.Naxis[n-1] != 2 {
+		return false
+	}
+	ctype, _ := h.Keys[Nth("CTYPE", n)].(string)
+	return strings.ToUpper(strings.TrimSpace(ctype)) == "COMPLEX"
+}
+
+// Stack combines a list of two-dimensional images with the same dimensions and BITPIX (e.g. the frames stored in the IMAGE extensions
+// of a multi-extension file) into a new three-dimensional image unit, where NAXIS3 is equal to len(units) and the k'th plane is units[k]
+// The header is copied from units[0]. The images should have the same BSCALE and BZERO, since a single pair applies to the result
+func Stack(units []*Unit) (*Unit, error) {
+	if len(units) == 0 {
+		return nil, fmt.Errorf("Stack needs at least one image")
+	}
+	h := units[0]
+	bscale, bzero := h.Scaling()
+	var data reflect.Value
+	for i, u := range units {
+		if !u.HasImage() || len(u.Naxis) != 2 {
+			return nil, fmt.Errorf("Unit %d is not a two-dimensional image", i)
+		}
+		if !sameShape(u.Naxis, h.Naxis) {
+			return nil, fmt.Errorf("Image dimensions do not match: %v vs %v", u.Naxis, h.Naxis)
+		}
+		if u.Bitpix() != h.Bitpix() {
+			return nil, fmt.Errorf("BITPIX does not match: %d vs %d", u.Bitpix(), h.Bitpix())
+		}
+		if s, z := u.Scaling(); s != bscale || z != bzero {
+			return nil, fmt.Errorf("BSCALE/BZERO of unit %d do not match", i)
+		}
+		src := reflect.ValueOf(u.Data)
+		if i == 0 {
+			data = reflect.MakeSlice(src.Type(), 0, src.Len()*len(units))
+		}
+		data = reflect.AppendSlice(data, src)
+	}
+
+	naxis := []int{h.Naxis[0], h.Naxis[1], len(units)}
+	g := &Unit{
+		Keys:       h.reshapeKeys(naxis),
+		Naxis:      naxis,
+		Data:       data.Interface(),
+		class:      h.class,
+		BlankCards: append([]string(nil), h.BlankCards...),
+		History:    append([]string(nil), h.History...),
+		Comments:   append([]string(nil), h.Comments...),
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+	}
+	g.setAccessors()
+	return g, nil
+}
+
+// isAxisKey returns true if key is a WCS key describing axis n, e.g. CTYPE3, CRPIX3, PC3_1, PC1_3 or CD3_3 for n=3
+func isAxisKey(key string, n int) bool {
+	for _, prefix := range []string{"CTYPE", "CRVAL", "CRPIX", "CDELT", "CUNIT", "CROTA"} {
+		if key == Nth(prefix, n) {
+			return true
+		}
+	}
+	for _, prefix := range []string{"PC", "CD"} {
+		var i, j int
+		if _, err := fmt.Sscanf(key, prefix+"%d_%d", &i, &j); err == nil && (i == n || j == n) {
+			return true
+		}
+	}
+	return false
+}
+
+// arith is a helper function for Sub and Div that applies op to the corresponding pixels of h and other
+func (h *Unit) arith(other *Unit, op func(x, y float64) float64) (*Unit, error) {
+	if !h.HasImage() || !other.HasImage() {
+		return nil, fmt.Errorf("Both units should contain an image")
+	}
+	if !sameShape(h.Naxis, other.Naxis) {
+		return nil, fmt.Errorf("Image dimensions do not match: %v vs %v", h.Naxis, other.Naxis)
+	}
+
+	x := h.floats()
+	y := other.floats()
+	data := make([]float64, len(x))
+	for i := range data {
+		data[i] = op(x[i], y[i]) // NaN (blank) inputs propagate to the result
+	}
+	return h.derive(h.Naxis, data), nil
+}
+
+// floats returns the physical values (after applying BSCALE/BZERO) of the pixels of h as a flat array ordered the same as Data
+// Blank pixels are set to NaN
+func (h *Unit) floats() []float64 {
+	bscale, bzero := h.Scaling()
+	p := make([]float64, product(h.Naxis))
+	a := make([]int, len(h.Naxis))
+	for i := range p {
+		if h.Blank(a...) {
+			p[i] = math.NaN()
+		} else {
+			p[i] = bzero + bscale*h.FloatAt(a...)
+		}
+		for k := range a { // advances a to the next pixel, NAXIS1 changes fastest
+			a[k]++
+			if a[k] < h.Naxis[k] {
+				break
+			}
+			a[k] = 0
+		}
+	}
+	return p
+}
+
+// derive returns a new float64 image unit (BITPIX=-64) with the given dimensions and data
+// The header is copied from h with the NAXISn keys updated. The keys that are not valid for the new data
+// (BSCALE, BZERO, BLANK, DATAMIN, DATAMAX, CHECKSUM and DATASUM) are removed
+func (h *Unit) derive(naxis []int, data []float64) *Unit {
+	keys := h.reshapeKeys(naxis)
+	for _, key := range []string{"BSCALE", "BZERO", "BLANK"} {
+		delete(keys, key)
+	}
+	keys["BITPIX"] = -64
+
+	g := &Unit{
+		Keys:       keys,
+		Naxis:      append([]int(nil), naxis...),
+		Data:       data,
+		class:      h.class,
+		BlankCards: append([]string(nil), h.BlankCards...),
+		History:    append([]string(nil), h.History...),
+		Comments:   append([]string(nil), h.Comments...),
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+	}
+	g.setAccessors()
+	return g
+}
+
+// reshapeKeys returns a copy of the header of h with NAXIS and NAXISn updated based on naxis
+// The keys that depend on the data values (DATAMIN, DATAMAX, CHECKSUM and DATASUM) are removed
+func (h *Unit) reshapeKeys(naxis []int) map[string]interface{} {
+	keys := copyKeys(h.Keys)
+	for _, key := range []string{"DATAMIN", "DATAMAX", "CHECKSUM", "DATASUM"} {
+		delete(keys, key)
+	}
+	for i := len(naxis) + 1; i <= len(h.Naxis); i++ {
+		delete(keys, Nth("NAXIS", i))
+	}
+	keys["NAXIS"] = len(naxis)
+	for i, x := range naxis {
+		keys[Nth("NAXIS", i+1)] = x
+	}
+	return keys
+}
+
+// sameShape returns true if a and b hold the same dimensions
+func sameShape(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyMask returns a copy of the science image h in which the pixels flagged as bad in the data quality image dq (e.g. the DQ
+// extension that accompanies a SCI extension) are blank, i.e. the pixels for which the value of dq has any of badBits set
+// dq should be an integral image (BITPIX > 0) with the same dimensions as h. The masked pixels are set to NaN for float images
+// and to BLANK for integral images with BLANK defined; otherwise, the result is a float64 image of the physical values (see derive)
+func (h *Unit) ApplyMask(dq *Unit, badBits int64) (*Unit, error) {
+	if !h.HasImage() || !dq.HasImage() {
+		return nil, fmt.Errorf("Both units should contain an image")
+	}
+	if !sameShape(h.Naxis, dq.Naxis) {
+		return nil, fmt.Errorf("Image dimensions do not match: %v vs %v", h.Naxis, dq.Naxis)
+	}
+	flags := reflect.ValueOf(dq.Data)
+	if dq.Bitpix() < 0 || flags.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("The data quality image should be integral")
+	}
+
+	var g *Unit
+	var blank reflect.Value // the value of the masked pixels
+	if b, ok := h.blankValue(); ok {
+		g = h.CloneWithData()
+		blank = reflect.ValueOf(b)
+	} else if h.Bitpix() < 0 {
+		g = h.CloneWithData()
+		blank = reflect.ValueOf(math.NaN())
+	} else {
+		g = h.derive(h.Naxis, h.floats())
+		blank = reflect.ValueOf(math.NaN())
+	}
+	data := reflect.ValueOf(g.Data)
+	blank = blank.Convert(data.Type().Elem())
+	for i := 0; i < flags.Len(); i++ {
+		var x int64
+		if f := flags.Index(i); f.Kind() == reflect.Uint8 {
+			x = int64(f.Uint())
+		} else {
+			x = f.Int()
+		}
+		if x&badBits != 0 {
+			data.Index(i).Set(blank)
+		}
+	}
+	g.InvalidateStats()
+	return g, nil
+}
+
+// BoolMask converts a BITPIX=8 image that holds a mask (e.g. a segmentation map) to a []bool with one element per pixel
+// in the order of Data, where nonzero pixels are true. An error is returned if h is not an image or BITPIX is not 8
+func (h *Unit) BoolMask() ([]bool, error) {
+	data, ok := h.Data.([]byte)
+	if !h.HasImage() || !ok {
+		return nil, fmt.Errorf("BoolMask needs a BITPIX=8 image")
+	}
+	mask := make([]bool, len(data))
+	for i, x := range data {
+		mask[i] = x != 0
+	}
+	return mask, nil
+}
+
+// ThresholdMask returns a []bool with one element per pixel in the order of Data, which is true for the pixels with a value
+// greater than t. The values are compared before applying BSCALE/BZERO (the same as Stats) and blank pixels are false
+// It is meant for float images, but works with any pixel type. The result is nil if h is not an image
+func (h *Unit) ThresholdMask(t float64) []bool {
+	if !h.HasImage() {
+		return nil
+	}
+	value := h.flatValue()
+	mask := make([]bool, product(h.Naxis))
+	for i := range mask {
+		x, ok := value(i)
+		mask[i] = ok && x > t
+	}
+	return mask
+}
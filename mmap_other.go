@@ -0,0 +1,14 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package fits
+
+import "fmt"
+
+// OpenMmap memory-maps a FITS file on Unix-like systems (see mmap_unix.go); it is not supported on this platform
+func OpenMmap(path string) (fits []*Unit, close func() error, err error) {
+	return nil, nil, fmt.Errorf("OpenMmap is not supported on this platform")
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fits
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+)
+
+// OpenMmap is similar to Open, but memory-maps the file given by path instead of reading it
+// For images with BITPIX=8, Data points directly into the mapped region (zero-copy). Such Data slices are read-only (writing to
+// them crashes the program) and are only valid until the returned close function is called, which unmaps the file
+// FITS data is big-endian, so the pixels of the other image types cannot be used in place on little-endian hosts; they are
+// decoded into newly allocated arrays as in Open. Tables are copied as well
+// OpenMmap is only available on Unix-like systems; it returns an error elsewhere
+func OpenMmap(path string) (fits []*Unit, close func() error, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.Size() == 0 { // an empty file cannot be mapped
+		fits, err = Open(f)
+		return fits, func() error { return nil }, err
+	}
+
+	m, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := NewReader(bytes.NewReader(m))
+	b.mapped = m
+	fits, err = b.readUnits()
+	if err != nil {
+		syscall.Munmap(m)
+		return nil, nil, err
+	}
+	return fits, func() error { return syscall.Munmap(m) }, nil
+}
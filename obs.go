@@ -0,0 +1,130 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+//
+// This file provides access to the observation metadata keys (DATE-OBS, MJD-OBS, EXPTIME and OBSGEO-X/Y/Z) as described in
+//  Rots A. H. et al. Representations of time coordinates in FITS. A&A 574, A36 (2015)
+// and to the provenance keys (ORIGIN, CREATOR, PROGRAM, DATE and HISTORY)
+
+package fits
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Observation holds the commonly used metadata of an observation as read from the header by Unit.Observation
+// Each value has a corresponding Has flag, which is false (and the value is zero) if the key is missing
+type Observation struct {
+	DateObs    time.Time  // DATE-OBS, the start time of the observation (UTC unless TIMESYS says otherwise)
+	HasDateObs bool       // DATE-OBS is present
+	MJDObs     float64    // MJD-OBS, the start time of the observation as a Modified Julian Date
+	HasMJDObs  bool       // MJD-OBS is present
+	ExpTime    float64    // EXPTIME, the exposure time in seconds
+	HasExpTime bool       // EXPTIME is present
+	ObsGeo     [3]float64 // OBSGEO-X, OBSGEO-Y and OBSGEO-Z, the geocentric coordinates of the observatory in meters
+	HasObsGeo  bool       // All three OBSGEO keys are present
+}
+
+// Observation collects the observation metadata from the header of h (see Observation)
+// The keys are read by Lookup, so they can be inherited from the primary header
+// An error is returned if any of the keys is present but its value cannot be parsed
+func (h *Unit) Observation() (obs Observation, err error) {
+	if value, ok := h.Lookup("DATE-OBS"); ok {
+		s, _ := value.(string)
+		if obs.DateObs, err = ParseDate(s); err != nil {
+			return obs, ErrBadValue{"DATE-OBS", fmt.Sprint(value)}
+		}
+		obs.HasDateObs = true
+	}
+	if obs.MJDObs, obs.HasMJDObs, err = h.lookupFloat("MJD-OBS"); err != nil {
+		return obs, err
+	}
+	if obs.ExpTime, obs.HasExpTime, err = h.lookupFloat("EXPTIME"); err != nil {
+		return obs, err
+	}
+	n := 0
+	for i, key := range []string{"OBSGEO-X", "OBSGEO-Y", "OBSGEO-Z"} {
+		var ok bool
+		if obs.ObsGeo[i], ok, err = h.lookupFloat(key); err != nil {
+			return obs, err
+		}
+		if ok {
+			n++
+		}
+	}
+	obs.HasObsGeo = n == 3
+	if !obs.HasObsGeo {
+		obs.ObsGeo = [3]float64{}
+	}
+	return obs, nil
+}
+
+// lookupFloat returns the value of a numerical key found by Lookup as float64
+// ok is false if the key is missing, and an error is returned if the key is present but is not a number
+func (h *Unit) lookupFloat(key string) (x float64, ok bool, err error) {
+	value, ok := h.Lookup(key)
+	if !ok {
+		return 0, false, nil
+	}
+	switch v := value.(type) {
+	case int:
+		return float64(v), true, nil
+	case float64:
+		return v, true, nil
+	}
+	return 0, false, ErrBadValue{key, fmt.Sprint(value)}
+}
+
+// Provenance holds the keys that describe who or what produced a file, as read from the header by Unit.Provenance
+// The string fields are empty if the corresponding key is missing
+type Provenance struct {
+	Origin  string    // ORIGIN, the organization or institution that created the file
+	Creator string    // CREATOR, the software that created the file
+	Program string    // PROGRAM, the software that created the file (a common alternative to CREATOR)
+	Date    string    // DATE, the date the file was created as written in the header
+	Created time.Time // DATE parsed by ParseDate, zero if DATE is missing or invalid
+	History []string  // The HISTORY lines (see Unit.History)
+}
+
+// Provenance collects the provenance keys of h (see Provenance), e.g. for logging what produced a file
+// The keys are read by Lookup, so they can be inherited from the primary header
+func (h *Unit) Provenance() Provenance {
+	str := func(key string) string {
+		value, _ := h.Lookup(key)
+		s, _ := value.(string)
+		return s
+	}
+	p := Provenance{
+		Origin:  str("ORIGIN"),
+		Creator: str("CREATOR"),
+		Program: str("PROGRAM"),
+		Date:    str("DATE"),
+		History: append([]string(nil), h.History...),
+	}
+	if p.Date != "" {
+		p.Created, _ = ParseDate(p.Date)
+	}
+	return p
+}
+
+// ParseDate parses the value of a FITS date key (e.g. DATE-OBS or DATE) in one of the formats allowed by the standard
+//
+//	YYYY-MM-DD
+//	YYYY-MM-DDThh:mm:ss[.s...]
+//	DD/MM/YY (the deprecated format, 19YY is assumed)
+//
+// The result is in UTC, since FITS dates carry no time zone
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	var d, m, y int
+	if n, _ := fmt.Sscanf(s, "%2d/%2d/%2d", &d, &m, &y); n == 3 && len(s) == 8 {
+		return time.Date(1900+y, time.Month(m), d, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid FITS date: %q", s)
+}
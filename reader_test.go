@@ -0,0 +1,153 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// The hand-rolled big-endian decoders used by Reader before encoding/binary; they are the reference for the fuzz tests
+
+func manualInt16(e []byte) int16 {
+	return int16(uint16(e[1]) | uint16(e[0])<<8)
+}
+
+func manualInt32(e []byte) int32 {
+	return int32(uint32(e[3]) | uint32(e[2])<<8 | uint32(e[1])<<16 | uint32(e[0])<<24)
+}
+
+func manualInt64(e []byte) int64 {
+	return int64(uint64(e[7]) | uint64(e[6])<<8 | uint64(e[5])<<16 | uint64(e[4])<<24 |
+		uint64(e[3])<<32 | uint64(e[2])<<40 | uint64(e[1])<<48 | uint64(e[0])<<56)
+}
+
+// straddling returns a Reader positioned shift bytes before the end of the first block, followed by value
+// For shift < len(value), the value straddles the block boundary
+func straddling(value []byte, shift uint8) *Reader {
+	n := 2880 - int(shift)%9
+	b := NewReader(bytes.NewReader(append(make([]byte, n), value...)))
+	b.Read(make([]byte, n))
+	return b
+}
+
+func FuzzReadInt16(f *testing.F) {
+	f.Add([]byte{0x80, 0x01}, uint8(1))
+	f.Add([]byte{0xff, 0xff}, uint8(0))
+	f.Fuzz(func(t *testing.T, value []byte, shift uint8) {
+		if len(value) < 2 {
+			return
+		}
+		value = value[:2]
+		if x, want := straddling(value, shift).ReadInt16(), manualInt16(value); x != want {
+			t.Errorf("ReadInt16(% x) = %d, want %d", value, x, want)
+		}
+	})
+}
+
+func FuzzReadInt32(f *testing.F) {
+	f.Add([]byte{0x80, 0, 0, 0x01}, uint8(3))
+	f.Add([]byte{0x7f, 0x80, 0, 0}, uint8(0))
+	f.Fuzz(func(t *testing.T, value []byte, shift uint8) {
+		if len(value) < 4 {
+			return
+		}
+		value = value[:4]
+		b := straddling(value, shift)
+		if x, want := b.ReadInt32(), manualInt32(value); x != want {
+			t.Errorf("ReadInt32(% x) = %d, want %d", value, x, want)
+		}
+		b = straddling(value, shift)
+		if x, want := b.ReadFloat32(), math.Float32frombits(uint32(manualInt32(value))); math.Float32bits(x) != math.Float32bits(want) {
+			t.Errorf("ReadFloat32(% x) = %v, want %v", value, x, want)
+		}
+	})
+}
+
+func FuzzReadInt64(f *testing.F) {
+	f.Add([]byte{0x80, 0, 0, 0, 0, 0, 0, 0x01}, uint8(5))
+	f.Add([]byte{0x7f, 0xf8, 0, 0, 0, 0, 0, 0}, uint8(0))
+	f.Fuzz(func(t *testing.T, value []byte, shift uint8) {
+		if len(value) < 8 {
+			return
+		}
+		value = value[:8]
+		b := straddling(value, shift)
+		if x, want := b.ReadInt64(), manualInt64(value); x != want {
+			t.Errorf("ReadInt64(% x) = %d, want %d", value, x, want)
+		}
+		b = straddling(value, shift)
+		if x, want := b.ReadFloat64(), math.Float64frombits(uint64(manualInt64(value))); math.Float64bits(x) != math.Float64bits(want) {
+			t.Errorf("ReadFloat64(% x) = %v, want %v", value, x, want)
+		}
+	})
+}
+
+// benchData is the input of the Reader benchmarks (64 blocks)
+var benchData = make([]byte, 64*2880)
+
+func BenchmarkReadInt16(b *testing.B) {
+	b.SetBytes(int64(len(benchData)))
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(benchData))
+		for k := 0; k < len(benchData)/2; k++ {
+			r.ReadInt16()
+		}
+	}
+}
+
+// BenchmarkReadInt16Manual decodes the same data with the hand-rolled decoder for comparison
+func BenchmarkReadInt16Manual(b *testing.B) {
+	b.SetBytes(int64(len(benchData)))
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(benchData))
+		for k := 0; k < len(benchData)/2; k++ {
+			r.Read(r.elem[0:2])
+			manualInt16(r.elem)
+		}
+	}
+}
+
+func BenchmarkReadInt32(b *testing.B) {
+	b.SetBytes(int64(len(benchData)))
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(benchData))
+		for k := 0; k < len(benchData)/4; k++ {
+			r.ReadInt32()
+		}
+	}
+}
+
+func BenchmarkReadInt32Manual(b *testing.B) {
+	b.SetBytes(int64(len(benchData)))
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(benchData))
+		for k := 0; k < len(benchData)/4; k++ {
+			r.Read(r.elem[0:4])
+			manualInt32(r.elem)
+		}
+	}
+}
+
+func BenchmarkReadInt64(b *testing.B) {
+	b.SetBytes(int64(len(benchData)))
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(benchData))
+		for k := 0; k < len(benchData)/8; k++ {
+			r.ReadInt64()
+		}
+	}
+}
+
+func BenchmarkReadInt64Manual(b *testing.B) {
+	b.SetBytes(int64(len(benchData)))
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(benchData))
+		for k := 0; k < len(benchData)/8; k++ {
+			r.Read(r.elem[0:8])
+			manualInt64(r.elem)
+		}
+	}
+}
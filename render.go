@@ -0,0 +1,192 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// ColorMap maps a normalized pixel value t in [0, 1] to a color
+// Blank pixels are passed as NaN. The color maps defined in this package return a transparent color for NaN; use WithFill to change it
+type ColorMap func(t float64) color.RGBA
+
+// Stretch maps a normalized pixel value in [0, 1] to [0, 1] before applying a ColorMap, e.g. to bring out the faint details
+type Stretch func(t float64) float64
+
+// Linear is the identity Stretch
+func Linear(t float64) float64 {
+	return t
+}
+
+// SqrtStretch is a square-root Stretch
+func SqrtStretch(t float64) float64 {
+	return math.Sqrt(t)
+}
+
+// LogStretch is a logarithmic Stretch, which compresses the bright end of the range
+func LogStretch(t float64) float64 {
+	const a = 1000
+	return math.Log(a*t+1) / math.Log(a+1)
+}
+
+// Grayscale is a ColorMap from black (t=0) to white (t=1)
+func Grayscale(t float64) color.RGBA {
+	if math.IsNaN(t) {
+		return color.RGBA{}
+	}
+	v := uint8(clamp(t)*255 + 0.5)
+	return color.RGBA{v, v, v, 255}
+}
+
+// Heat is a ColorMap from black through red and yellow to white
+func Heat(t float64) color.RGBA {
+	return interpolate(t, [][3]float64{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {1, 1, 1}})
+}
+
+// Viridis is an approximation of the perceptually uniform viridis ColorMap (dark blue to yellow)
+func Viridis(t float64) color.RGBA {
+	return interpolate(t, [][3]float64{
+		{0.267, 0.005, 0.329}, {0.283, 0.141, 0.458}, {0.254, 0.265, 0.530}, {0.207, 0.372, 0.553}, {0.164, 0.471, 0.558},
+		{0.128, 0.567, 0.551}, {0.135, 0.659, 0.518}, {0.267, 0.749, 0.441}, {0.478, 0.821, 0.318}, {0.741, 0.873, 0.150},
+		{0.993, 0.906, 0.144},
+	})
+}
+
+// WithFill returns a ColorMap similar to cmap, except that the blank pixels are mapped to fill
+func WithFill(cmap ColorMap, fill color.RGBA) ColorMap {
+	return func(t float64) color.RGBA {
+		if math.IsNaN(t) {
+			return fill
+		}
+		return cmap(t)
+	}
+}
+
+// interpolate is a helper function for color maps that linearly interpolates between equally spaced colors (RGB in [0, 1])
+func interpolate(t float64, colors [][3]float64) color.RGBA {
+	if math.IsNaN(t) {
+		return color.RGBA{}
+	}
+	x := clamp(t) * float64(len(colors)-1)
+	i := int(x)
+	if i == len(colors)-1 {
+		i--
+	}
+	f := x - float64(i)
+	var c [3]uint8
+	for k := range c {
+		c[k] = uint8((colors[i][k]*(1-f)+colors[i+1][k]*f)*255 + 0.5)
+	}
+	return color.RGBA{c[0], c[1], c[2], 255}
+}
+
+// clamp limits t to [0, 1]; NaN is mapped to 0
+func clamp(t float64) float64 {
+	if math.IsNaN(t) {
+		return 0
+	}
+	return math.Max(0, math.Min(1, t))
+}
+
+// normalize maps x linearly from [min, max] to [0, 1] (values outside the range are clamped)
+// If the range is empty, inverted or not finite (e.g. a constant image, for which min == max, or an image without
+// valid pixels, for which both are NaN), the result is 0.5 (mid-gray) instead of NaN
+func normalize(x, min, max float64) float64 {
+	t := (x - min) / (max - min)
+	if !validRange(min, max) || math.IsNaN(t) {
+		return 0.5
+	}
+	return clamp(t)
+}
+
+// validRange returns true if [min, max] is a non-empty finite range that can be used by normalize
+func validRange(min, max float64) bool {
+	return max > min && !math.IsInf(max-min, 0)
+}
+
+// EncodePNGColor writes a two-dimensional plane of the image in h to w as a false-color PNG image (see ToImageColor)
+// plane holds the coordinates along NAXIS3, NAXIS4... and selects the plane to encode; it should be empty for two-dimensional images
+func (h *Unit) EncodePNGColor(w io.Writer, cmap ColorMap, stretch Stretch, plane []int) error {
+	img, err := h.ToImageColor(cmap, stretch, plane)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// ToImage converts a two-dimensional plane of the image in h to a grayscale *image.Gray16, which can be used with the standard
+// image packages (image/draw, image/jpeg...). The pixel values are normalized based on the minimum and maximum of the whole
+// image (see Stats) and passed through stretch (nil means Linear). Blank pixels are black and the pixels of a constant image
+// are mid-gray (stretch is not applied, since the normalized values are meaningless)
+// plane is the same as in EncodePNGColor. As is customary for astronomical images, the first row of the image (NAXIS2=0)
+// is placed at the bottom, i.e. the pixel (x, y) of h is at (x, NAXIS2-1-y) in the result
+func (h *Unit) ToImage(stretch Stretch, plane []int) (image.Image, error) {
+	if !h.HasImage() || len(h.Naxis) < 2 {
+		return nil, fmt.Errorf("ToImage needs an image with at least two dimensions")
+	}
+	img := image.NewGray16(image.Rect(0, 0, h.Naxis[0], h.Naxis[1]))
+	err := h.eachPixel(stretch, plane, func(x, y int, t float64) {
+		if !math.IsNaN(t) {
+			img.SetGray16(x, y, color.Gray16{uint16(t*65535 + 0.5)})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// ToImageColor is similar to ToImage, but returns a false-color *image.RGBA, in which the normalized pixel values are mapped
+// to colors by cmap. Blank pixels are passed to cmap as NaN
+func (h *Unit) ToImageColor(cmap ColorMap, stretch Stretch, plane []int) (image.Image, error) {
+	if !h.HasImage() || len(h.Naxis) < 2 {
+		return nil, fmt.Errorf("ToImageColor needs an image with at least two dimensions")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, h.Naxis[0], h.Naxis[1]))
+	err := h.eachPixel(stretch, plane, func(x, y int, t float64) {
+		img.SetRGBA(x, y, cmap(t))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// eachPixel is a helper function for ToImage and ToImageColor that calls fn for each pixel of the plane of a two-dimensional
+// image selected by plane. fn receives the image coordinates (already flipped vertically) and the normalized and stretched
+// value of the pixel in [0, 1], or NaN for blank pixels
+func (h *Unit) eachPixel(stretch Stretch, plane []int, fn func(x, y int, t float64)) error {
+	if len(plane) != len(h.Naxis)-2 {
+		return fmt.Errorf("Expected %d plane coordinates, got %d", len(h.Naxis)-2, len(plane))
+	}
+	a := append([]int{0, 0}, plane...)
+	if err := h.checkCoords(a); err != nil {
+		return err
+	}
+	if stretch == nil {
+		stretch = Linear
+	}
+
+	min, max := h.Stats()
+	if !validRange(min, max) { // a constant image (or one without valid pixels) is mid-gray regardless of stretch
+		stretch = Linear
+	}
+	nx, ny := h.Naxis[0], h.Naxis[1]
+	for y := 0; y < ny; y++ {
+		for x := 0; x < nx; x++ {
+			a[0], a[1] = x, y
+			t := math.NaN()
+			if !h.Blank(a...) {
+				t = clamp(stretch(normalize(h.FloatAt(a...), min, max)))
+			}
+			fn(x, ny-1-y, t)
+		}
+	}
+	return nil
+}
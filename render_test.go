@@ -0,0 +1,25 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"image"
+	"testing"
+)
+
+func TestToImageConstant(t *testing.T) {
+	h := image16(t, nil, 7, 7, 7, 7)
+	for _, stretch := range []Stretch{nil, Linear, SqrtStretch, LogStretch} {
+		img, err := h.ToImage(stretch, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := img.(*image.Gray16)
+		for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+			if y := g.Gray16At(p.X, p.Y).Y; y != 32768 {
+				t.Errorf("pixel %v: got %d, want 32768 (mid-gray)", p, y)
+			}
+		}
+	}
+}
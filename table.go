@@ -0,0 +1,590 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ColumnSpec describes a field of a binary table created by NewBinTable
+// Name, Unit and Disp are optional and become the values of TTYPEn, TUNITn and TDISPn, respectively
+// Form is the TFORMn value (e.g. "J", "10A" or "3E")
+type ColumnSpec struct {
+	Name string
+	Form string
+	Unit string
+	Disp string
+}
+
+// NewBinTable creates a new binary table unit (XTENSION=BINTABLE) in memory with the given fields and number of rows
+// The header is generated based on cols and Data is allocated and zeroed. The accessor functions (Field, Format...) work as usual
+// and the cells can be populated by SetCell. The result can be written by Write or WriteTable
+// For variable length array fields (e.g. Form="1PE" or "QD"), the arrays are stored in the heap as they are set by SetCell
+// and PCOUNT is updated accordingly. Packed bits (X) are not supported
+func NewBinTable(cols []ColumnSpec, nrows int) (*Unit, error) {
+	if nrows < 0 {
+		return nil, fmt.Errorf("Invalid number of rows: %d", nrows)
+	}
+	keys := map[string]interface{}{
+		"XTENSION": "BINTABLE",
+		"BITPIX":   8,
+		"NAXIS":    2,
+		"NAXIS2":   nrows,
+		"PCOUNT":   0,
+		"GCOUNT":   1,
+		"TFIELDS":  len(cols),
+	}
+
+	width := 0
+	for i, c := range cols {
+		code, repeat, err := binaryForm(c.Form)
+		if err != nil {
+			return nil, err
+		}
+		if code == 'X' {
+			return nil, fmt.Errorf("Binary table form %c is not supported by NewBinTable", code)
+		}
+		width += binarySize(code, repeat)
+
+		keys[Nth("TFORM", i+1)] = c.Form
+		if c.Name != "" {
+			keys[Nth("TTYPE", i+1)] = c.Name
+		}
+		if c.Unit != "" {
+			keys[Nth("TUNIT", i+1)] = c.Unit
+		}
+		if c.Disp != "" {
+			keys[Nth("TDISP", i+1)] = c.Disp
+		}
+	}
+	keys["NAXIS1"] = width
+
+	h := &Unit{
+		Keys:  keys,
+		Naxis: []int{width, nrows},
+		Data:  make([]byte, width*nrows),
+		class: "BINTABLE",
+	}
+	if err := h.buildTable(true); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// binaryTypes maps the binary table type codes to the Go types of the values returned by the accessor functions
+var binaryTypes = map[byte]reflect.Type{
+	'L': reflect.TypeOf(false),
+	'B': reflect.TypeOf(uint8(0)),
+	'I': reflect.TypeOf(int16(0)),
+	'J': reflect.TypeOf(int32(0)),
+	'K': reflect.TypeOf(int64(0)),
+	'E': reflect.TypeOf(float32(0)),
+	'D': reflect.TypeOf(float64(0)),
+	'C': reflect.TypeOf(complex64(0)),
+	'M': reflect.TypeOf(complex128(0)),
+}
+
+// SetCell sets the value of the cell pointed by col and row in a binary table; col is defined as in Field
+// The type of v should match the type returned by the accessor function of the field, e.g. int32 for TFORM=J,
+// []float32 of length 3 for TFORM=3E, or a string for TFORM=rA (strings shorter than r are padded with spaces)
+// For variable length array fields, v is a slice of any length of the element type (e.g. []float32 for TFORM=1PE) or a
+// string for TFORM=1PA. The array is appended to the heap, the descriptor of the cell is updated to point to it and
+// PCOUNT is set to the new size of the heap. Note that the previous array of the cell is not removed from the heap
+func (h *Unit) SetCell(col interface{}, row int, v interface{}) error {
+	if h.class != "BINTABLE" {
+		return fmt.Errorf("SetCell needs a BINTABLE unit")
+	}
+	n := h.fieldIndex(col)
+	if n == -1 {
+		return fmt.Errorf("Field %v not found", col)
+	}
+	if row < 0 || row >= h.Naxis[1] {
+		return fmt.Errorf("Row %d is out of range [0, %d)", row, h.Naxis[1])
+	}
+	if err := h.checkValue(col, n, v); err != nil {
+		return err
+	}
+	c := h.columns[n]
+	cell := h.Data.([]byte)[row*h.Naxis[0]+c.offset:][:c.width(true)]
+	if c.elem != 0 {
+		return h.setArray(col, n, row, cell, v)
+	}
+
+	if c.code == 'A' {
+		s := v.(string)
+		copy(cell, s+strings.Repeat(" ", c.repeat-len(s)))
+		return nil
+	}
+
+	value := reflect.ValueOf(v)
+	if c.code == 'L' { // logical values are stored as 'T' and 'F'
+		for i := 0; i < c.repeat; i++ {
+			var x bool
+			if value.Kind() == reflect.Slice {
+				x = value.Index(i).Bool()
+			} else {
+				x = value.Bool()
+			}
+			cell[i] = 'F'
+			if x {
+				cell[i] = 'T'
+			}
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+		return err
+	}
+	copy(cell, buf.Bytes())
+	return nil
+}
+
+// checkValue verifies that v has the type (and the number of elements) that SetCell accepts for the n'th field (0-based)
+// col is as passed to SetCell and is used in the error messages
+func (h *Unit) checkValue(col interface{}, n int, v interface{}) error {
+	c := h.columns[n]
+	value := reflect.ValueOf(v)
+	if c.elem != 0 { // variable length arrays accept a slice of any length
+		_, str := v.(string)
+		_, bools := v.([]bool)
+		t, ok := binaryTypes[c.elem]
+		switch {
+		case c.elem == 'A' && str, c.elem == 'L' && bools:
+		case c.elem != 'A' && c.elem != 'L' && ok && value.Kind() == reflect.Slice && value.Type().Elem() == t:
+		default:
+			return fmt.Errorf("Type mismatch for field %v: %T", col, v)
+		}
+		return nil
+	}
+
+	if c.code == 'A' {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("Field %v needs a string, got %T", col, v)
+		}
+		if len(s) > c.repeat {
+			return fmt.Errorf("String is too long for field %v (%d > %d)", col, len(s), c.repeat)
+		}
+		return nil
+	}
+
+	t, ok := binaryTypes[c.code]
+	if !ok {
+		return fmt.Errorf("Binary table form %c is not supported by SetCell", c.code)
+	}
+	switch {
+	case !value.IsValid(): // v is nil
+		return fmt.Errorf("Type mismatch for field %v: %T", col, v)
+	case c.repeat == 1 && value.Type() == t:
+	case value.Kind() == reflect.Slice && value.Type().Elem() == t:
+		if value.Len() != c.repeat {
+			return fmt.Errorf("Field %v needs %d elements, got %d", col, c.repeat, value.Len())
+		}
+	default:
+		return fmt.Errorf("Type mismatch for field %v: %T", col, v)
+	}
+	return nil
+}
+
+// AppendRow adds a row to the end of a binary table, e.g. to build a table created by NewBinTable row by row
+// values holds one value for each field in order, with the same types as accepted by SetCell (e.g. []float32 of length 3
+// for TFORM=3E). Data grows by one row and NAXIS2 (and THEAP, if present) is updated
+// If the number of values does not match TFIELDS or a value does not match its field, an error is returned and the table
+// is left unchanged
+func (h *Unit) AppendRow(values ...interface{}) error {
+	if h.class != "BINTABLE" {
+		return fmt.Errorf("AppendRow needs a BINTABLE unit")
+	}
+	if len(values) != len(h.columns) {
+		return fmt.Errorf("AppendRow needs %d values, got %d", len(h.columns), len(values))
+	}
+
+	for i, v := range values { // the values are checked before the table grows
+		if err := h.checkValue(i, i, v); err != nil {
+			return err
+		}
+	}
+
+	data, heap := h.Data.([]byte), h.heap // the state to restore on error
+	pcount, hasPcount := h.Keys["PCOUNT"]
+	theap, hasTheap := h.Keys["THEAP"]
+	width, row := h.Naxis[0], h.Naxis[1]
+	h.Data = append(data[:len(data):len(data)], make([]byte, width)...)
+	h.Naxis[1]++
+	h.Keys["NAXIS2"] = h.Naxis[1]
+	if x, ok := theap.(int); ok { // keeps the gap between the main table and the heap unchanged
+		h.Keys["THEAP"] = x + width
+	}
+	for i := range h.columns {
+		if h.columns[i].elem != 0 {
+			h.columns[i].descs = append(h.columns[i].descs, Descriptor{})
+		}
+	}
+
+	for i, v := range values {
+		if err := h.SetCell(i, row, v); err != nil {
+			h.Data, h.heap = data, heap
+			h.Naxis[1]--
+			h.Keys["NAXIS2"] = row
+			restoreKey(h.Keys, "PCOUNT", pcount, hasPcount)
+			restoreKey(h.Keys, "THEAP", theap, hasTheap)
+			for i := range h.columns {
+				if h.columns[i].elem != 0 {
+					h.columns[i].descs = h.columns[i].descs[:row]
+				}
+			}
+			return err
+		}
+	}
+
+	warnings := h.Warnings
+	err := h.buildTable(true) // the accessor functions refer to the previous Data
+	h.Warnings = warnings     // replaces the warnings repeated by buildTable
+	return err
+}
+
+// restoreKey sets keys[key] to value if present is true, or removes key otherwise
+func restoreKey(keys map[string]interface{}, key string, value interface{}, present bool) {
+	if present {
+		keys[key] = value
+	} else {
+		delete(keys, key)
+	}
+}
+
+// setArray is a helper function for SetCell that appends v (already checked by checkValue) to the heap and writes its
+// descriptor to cell. col is as passed to SetCell and n is the 0-based index of the field it points to
+func (h *Unit) setArray(col interface{}, n int, row int, cell []byte, v interface{}) error {
+	c := &h.columns[n]
+	var p []byte
+	var count int
+	switch x := v.(type) { // the type of v has been checked by checkValue
+	case string:
+		p, count = []byte(x), len(x)
+	case []bool:
+		p, count = make([]byte, len(x)), len(x)
+		for i, b := range x {
+			p[i] = 'F'
+			if b {
+				p[i] = 'T'
+			}
+		}
+	default:
+		value := reflect.ValueOf(v)
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return err
+		}
+		p, count = buf.Bytes(), value.Len()
+	}
+
+	if gap := h.heapGap(); len(h.heap) < gap { // THEAP is set, but the heap is empty
+		h.heap = make([]byte, gap)
+	}
+	offset := len(h.heap) - h.heapGap()
+	if c.code == 'P' && len(h.heap)+len(p) > math.MaxInt32 {
+		return fmt.Errorf("Heap is too large for the 32-bit descriptors of field %v (use Q instead of P)", col)
+	}
+	h.heap = append(h.heap, p...)
+	h.Keys["PCOUNT"] = len(h.heap)
+
+	if c.code == 'P' {
+		binary.BigEndian.PutUint32(cell, uint32(count))
+		binary.BigEndian.PutUint32(cell[4:], uint32(offset))
+	} else {
+		binary.BigEndian.PutUint64(cell, uint64(count))
+		binary.BigEndian.PutUint64(cell[8:], uint64(offset))
+	}
+	c.descs[row] = Descriptor{int64(count), int64(offset)}
+	return nil
+}
+
+// VarArray returns the variable length array stored in the heap for the cell pointed by col and row in a binary table
+// col is defined as in Field and should point to a variable length array field (TFORM=rPt or rQt)
+// The result is a slice of the type returned by the accessor functions for the element type t, e.g. []float32 for TFORM=1PE,
+// except that a string is returned for t=A and []bool for t=L. For t=X, the bytes holding the bits are returned
+func (h *Unit) VarArray(col interface{}, row int) (interface{}, error) {
+	n := h.fieldIndex(col)
+	if n == -1 {
+		return nil, fmt.Errorf("Field %v not found", col)
+	}
+	c := h.columns[n]
+	if c.elem == 0 {
+		return nil, fmt.Errorf("Field %v is not a variable length array", col)
+	}
+	if row < 0 || row >= len(c.descs) {
+		return nil, fmt.Errorf("Row %d is out of range [0, %d)", row, len(c.descs))
+	}
+	d := c.descs[row]
+	size := int64(binarySize(c.elem, int(d.Count)))
+	start := int64(h.heapGap()) + d.Offset
+	if d.Count < 0 || d.Offset < 0 || start+size > int64(len(h.heap)) {
+		return nil, fmt.Errorf("Descriptor %v of field %v points outside the heap", d, col)
+	}
+	p := h.heap[start : start+size]
+
+	switch c.elem {
+	case 'A':
+		return string(p), nil
+	case 'L':
+		x := make([]bool, len(p))
+		for i := range p {
+			x[i] = p[i] == 'T'
+		}
+		return x, nil
+	case 'B', 'X':
+		return append([]byte(nil), p...), nil
+	}
+	x := reflect.MakeSlice(reflect.SliceOf(binaryTypes[c.elem]), int(d.Count), int(d.Count)).Interface()
+	if err := binary.Read(bytes.NewReader(p), binary.BigEndian, x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// heapGap returns the number of bytes between the end of the main table and the start of the heap (THEAP - NAXIS1 * NAXIS2)
+func (h *Unit) heapGap() int {
+	theap, ok := h.Keys["THEAP"].(int)
+	if !ok {
+		return 0
+	}
+	return theap - h.Naxis[0]*h.Naxis[1]
+}
+
+// ColumnFloat64 returns the values of a numeric field of a binary table for all the rows as a []float64; col is defined as in Field
+// The field should be a scalar (repeat count of 1) of type B, I, J, K, E or D. The values are the same as returned by the
+// accessor function of the field (including TSCAL/TZERO scaling of integer fields, see scaledInts), but ColumnFloat64
+// decodes Data directly in a tight loop, which is much faster than calling the accessor function for each row
+func (h *Unit) ColumnFloat64(col interface{}) ([]float64, error) {
+	c, err := h.numericColumn(col, "BIJKED")
+	if err != nil {
+		return nil, err
+	}
+	p := make([]float64, h.Naxis[1])
+	data := h.Data.([]byte)
+	k := h.fieldIndex(col) + 1
+	signed := c.code == 'B' && h.isSignedByte(k)
+	tscal, tzero, scaled, _ := h.fieldScaling(k)
+	scaled = scaled && !signed
+	for row, off := 0, c.offset; row < len(p); row, off = row+1, off+h.Naxis[0] {
+		switch c.code {
+		case 'E':
+			p[row] = float64(math.Float32frombits(binary.BigEndian.Uint32(data[off:])))
+		case 'D':
+			p[row] = math.Float64frombits(binary.BigEndian.Uint64(data[off:]))
+		default:
+			p[row] = float64(decodeInt(c.code, signed, data[off:]))
+			if scaled {
+				p[row] = tzero + tscal*p[row]
+			}
+		}
+	}
+	return p, nil
+}
+
+// ColumnInt64 is similar to ColumnFloat64, but returns the values of an integer field (type B, I, J or K) as a []int64
+// An error is returned if the field is scaled by a non-integral TSCAL or TZERO, since its values are not integers
+func (h *Unit) ColumnInt64(col interface{}) ([]int64, error) {
+	c, err := h.numericColumn(col, "BIJK")
+	if err != nil {
+		return nil, err
+	}
+	p := make([]int64, h.Naxis[1])
+	data := h.Data.([]byte)
+	k := h.fieldIndex(col) + 1
+	signed := c.code == 'B' && h.isSignedByte(k)
+	tscal, tzero, scaled, integral := h.fieldScaling(k)
+	scaled = scaled && !signed
+	if scaled && !integral {
+		return nil, fmt.Errorf("Field %v has non-integral TSCAL/TZERO, use ColumnFloat64", col)
+	}
+	if c.code == 'K' && h.isUnsigned(k, c.code) {
+		return nil, fmt.Errorf("Field %v holds uint64 values, which do not fit in int64", col)
+	}
+	for row, off := 0, c.offset; row < len(p); row, off = row+1, off+h.Naxis[0] {
+		p[row] = decodeInt(c.code, signed, data[off:])
+		if scaled {
+			p[row] = int64(tzero) + int64(tscal)*p[row]
+		}
+	}
+	return p, nil
+}
+
+// ColumnMat returns the values of the numeric fields given by cols (each defined as in Field) as a flat row-major matrix
+// with one row per table row and one column per field, i.e. m[row*ncols+k] is the value of cols[k] in row, together
+// with its dimensions. The result can be passed directly to matrix libraries, e.g. mat.NewDense(nrows, ncols, m) in gonum
+// The fields are read by ColumnFloat64, so each should be a numeric scalar (array fields are rejected)
+func (h *Unit) ColumnMat(cols ...interface{}) (m []float64, nrows int, ncols int, err error) {
+	if h.class != "BINTABLE" {
+		return nil, 0, 0, fmt.Errorf("Column access needs a BINTABLE unit")
+	}
+	nrows, ncols = h.Naxis[1], len(cols)
+	m = make([]float64, nrows*ncols)
+	for k, col := range cols {
+		p, err := h.ColumnFloat64(col)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		for row, x := range p {
+			m[row*ncols+k] = x
+		}
+	}
+	return m, nrows, ncols, nil
+}
+
+// ColumnRange returns the range of values declared in the header for the field pointed by col (defined as in Field), e.g. to
+// scale a plot without scanning the data. The data range (TDMINn and TDMAXn) is used if present, otherwise the legal range
+// (TLMINn and TLMAXn). ok is false if the field does not exist or neither pair of keys is present
+func (h *Unit) ColumnRange(col interface{}) (min, max float64, ok bool) {
+	n := h.fieldIndex(col)
+	if n == -1 {
+		return 0, 0, false
+	}
+	for _, prefix := range []string{"TD", "TL"} {
+		min, okMin := h.floatKey(Nth(prefix+"MIN", n+1))
+		max, okMax := h.floatKey(Nth(prefix+"MAX", n+1))
+		if okMin && okMax {
+			return min, max, true
+		}
+	}
+	return 0, 0, false
+}
+
+// numericColumn is a helper function for ColumnFloat64 and ColumnInt64 that returns the layout of the field pointed by col
+// An error is returned if h is not a binary table or the field is not a scalar with one of the type codes in codes
+func (h *Unit) numericColumn(col interface{}, codes string) (column, error) {
+	if h.class != "BINTABLE" {
+		return column{}, fmt.Errorf("Column access needs a BINTABLE unit")
+	}
+	n := h.fieldIndex(col)
+	if n == -1 {
+		return column{}, fmt.Errorf("Field %v not found", col)
+	}
+	c := h.columns[n]
+	if c.repeat != 1 || !strings.ContainsRune(codes, rune(c.code)) {
+		return column{}, fmt.Errorf("Field %v is not a scalar of type %s", col, strings.Join(strings.Split(codes, ""), ", "))
+	}
+	return c, nil
+}
+
+// decodeInt decodes a big-endian integer of the binary table type code (B, I, J or K) stored at the start of p
+// If signed is true, a byte (B) is interpreted as a signed byte (TZERO=-128, see signedBytes)
+func decodeInt(code byte, signed bool, p []byte) int64 {
+	switch code {
+	case 'B':
+		if signed {
+			return int64(p[0]) - 128
+		}
+		return int64(p[0])
+	case 'I':
+		return int64(int16(binary.BigEndian.Uint16(p)))
+	case 'J':
+		return int64(int32(binary.BigEndian.Uint32(p)))
+	}
+	return int64(binary.BigEndian.Uint64(p))
+}
+
+// ToBinTable converts a text table (XTENSION=TABLE) to a new binary table unit with the same fields and rows
+// The fields are converted as follows:
+//
+//	Aw   -> wA (strings)
+//	Iw   -> J (int32), or K (int64) if w > 9
+//	Dw.d -> D (float64)
+//	Ew.d -> E (float32) if w <= 9, otherwise D (float64); a float32 holds about 7 significant digits
+//	Fw.d -> same as Ew.d
+//
+// Use ToBinTableWith to choose the type of all the real (D, E and F) fields. The field keys other than TFORMn and TBCOLn
+// (TTYPEn, TUNITn, TDISPn...) and the rest of the header keys (e.g. EXTNAME) are copied
+func (h *Unit) ToBinTable() (*Unit, error) {
+	return h.ToBinTableWith(0)
+}
+
+// ToBinTableWith is similar to ToBinTable, but real is the binary type code of the real fields: 'E' (float32), 'D' (float64)
+// or 0 for the default choice described in ToBinTable
+func (h *Unit) ToBinTableWith(real byte) (*Unit, error) {
+	if h.class != "TABLE" {
+		return nil, fmt.Errorf("ToBinTable needs a TABLE unit")
+	}
+	if real != 0 && real != 'E' && real != 'D' {
+		return nil, fmt.Errorf("Invalid type code for real fields: %c", real)
+	}
+
+	cols := make([]ColumnSpec, len(h.columns))
+	for i, c := range h.columns {
+		switch code := c.code; {
+		case code == 'A':
+			cols[i].Form = fmt.Sprintf("%dA", c.repeat)
+		case code == 'I' && c.repeat > 9:
+			cols[i].Form = "K"
+		case code == 'I':
+			cols[i].Form = "J"
+		case real != 0:
+			cols[i].Form = string(real)
+		case code == 'D' || c.repeat > 9:
+			cols[i].Form = "D"
+		default:
+			cols[i].Form = "E"
+		}
+	}
+	g, err := NewBinTable(cols, h.Naxis[1])
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range h.Keys { // the rest of the keys, except for the structure keys set by NewBinTable, TNULLn and the defaults
+		if !isStructureKey(key) && !strings.HasPrefix(key, "TNULL") && !h.isDefault(key) { // TNULLn is a string in text tables
+			g.Keys[key] = value
+		}
+	}
+	g.BlankCards = append([]string(nil), h.BlankCards...)
+	g.History = append([]string(nil), h.History...)
+	g.Comments = append([]string(nil), h.Comments...)
+	g.order = append([]string(nil), h.order...)
+	g.tokens = h.tokens
+	if err := g.buildTable(true); err != nil { // the accessors are rebuilt, as TDISPn and the rest of the field keys have changed
+		return nil, err
+	}
+
+	for i, c := range h.columns {
+		fn := h.list[i]
+		for row := 0; row < h.Naxis[1]; row++ {
+			var v interface{}
+			switch x := fn(row).(type) {
+			case string:
+				v = x
+			case int:
+				v = int32(x)
+			case int64: // Iw with w > 9
+				v = x
+			case float64:
+				v = x
+				if cols[i].Form == "E" {
+					v = float32(x)
+				}
+			default:
+				return nil, fmt.Errorf("Unexpected value %v (%T) in field %d of type %c", x, x, i, c.code)
+			}
+			if err := g.SetCell(i, row, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return g, nil
+}
+
+// isStructureKey returns true if key describes the structure of a table (e.g. NAXIS1, TFORMn or TBCOLn) rather than its content
+func isStructureKey(key string) bool {
+	switch key {
+	case "XTENSION", "BITPIX", "NAXIS", "NAXIS1", "NAXIS2", "PCOUNT", "GCOUNT", "TFIELDS", "THEAP", "END":
+		return true
+	}
+	return strings.HasPrefix(key, "TFORM") || strings.HasPrefix(key, "TBCOL")
+}
@@ -0,0 +1,289 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// newTable returns a table created by NewBinTable with four fields: A (J), B (E), C (I) and V (2D)
+func newTable(t testing.TB, nrows int) *Unit {
+	h, err := NewBinTable([]ColumnSpec{{Name: "A", Form: "J"}, {Name: "B", Form: "E"}, {Name: "C", Form: "I"}, {Name: "V", Form: "2D"}}, nrows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestSetCellNil(t *testing.T) {
+	h := newTable(t, 1)
+	for _, col := range []string{"A", "B", "C", "V"} {
+		if err := h.SetCell(col, 0, nil); err == nil {
+			t.Errorf("SetCell(%q, 0, nil) should fail", col)
+		}
+	}
+}
+
+// number converts the numeric value returned by an accessor function to float64
+func number(v interface{}) float64 {
+	x := reflect.ValueOf(v)
+	switch x.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(x.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(x.Uint())
+	}
+	return x.Float()
+}
+
+func TestColumnMatchesField(t *testing.T) {
+	// S is a signed byte (TZERO=-128), U is an unsigned int16 (TZERO=32768), T is scaled by TSCAL and V is a plain int32
+	var rows []byte
+	for i := 0; i < 5; i++ {
+		rows = append(rows, byte(i*60))
+		rows = append(rows, int16s(int16(i*20000-40000+i))...)
+		rows = append(rows, int16s(int16(i-2))...)
+		rows = append(rows, 0, 0, 0, byte(i))
+	}
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "9"), card("NAXIS2", "5"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "4"), card("TFORM1", "'B'"), card("TTYPE1", "'S'"), card("TZERO1", "-128"),
+		card("TFORM2", "'I'"), card("TTYPE2", "'U'"), card("TZERO2", "32768"),
+		card("TFORM3", "'I'"), card("TTYPE3", "'T'"), card("TSCAL3", "0.5"), card("TZERO3", "10"),
+		card("TFORM4", "'J'"), card("TTYPE4", "'V'")}, rows))
+	h := fits[1]
+	for _, col := range []string{"S", "U", "T", "V"} {
+		p, err := h.ColumnFloat64(col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		field := h.Field(col)
+		for row, x := range p {
+			if want := number(field(row)); x != want {
+				t.Errorf("field %s, row %d: got %v, want %v", col, row, x, want)
+			}
+		}
+		if col == "T" {
+			if _, err := h.ColumnInt64(col); err == nil {
+				t.Error("ColumnInt64 should reject a field with a non-integral TSCAL")
+			}
+			continue
+		}
+		q, err := h.ColumnInt64(col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for row, x := range q {
+			if want := number(field(row)); float64(x) != want {
+				t.Errorf("field %s, row %d: got %v, want %v", col, row, x, want)
+			}
+		}
+	}
+}
+
+func BenchmarkColumnFloat64(b *testing.B) {
+	h := newTable(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ColumnFloat64("A")
+	}
+}
+
+// BenchmarkColumnField reads the same column by calling the accessor function for each row for comparison
+func BenchmarkColumnField(b *testing.B) {
+	h := newTable(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		field := h.Field("A")
+		p := make([]float64, h.Naxis[1])
+		for row := range p {
+			p[row] = float64(field(row).(int32))
+		}
+	}
+}
+
+func TestScaledIntField(t *testing.T) {
+	rows := []byte{0, 0, 0, 3, 0xff, 0xff, 0xff, 0xfc} // 3 and -4
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "4"), card("NAXIS2", "2"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "1"), card("TFORM1", "'J'"), card("TTYPE1", "'A'"), card("TSCAL1", "0.5")}, rows))
+	h := fits[1]
+	for row, want := range []float64{1.5, -2} {
+		if x, ok := h.Field("A")(row).(float64); !ok || x != want {
+			t.Errorf("row %d: got %#v, want float64(%v)", row, h.Field("A")(row), want)
+		}
+	}
+	if _, err := h.ColumnInt64("A"); err == nil {
+		t.Error("ColumnInt64 should reject a field with TSCAL=0.5")
+	}
+}
+
+func TestUnsignedFields(t *testing.T) {
+	var rows []byte
+	for _, x := range []uint64{60000, 0} { // stored with the sign bit flipped, i.e. value - TZERO
+		rows = binary.BigEndian.AppendUint16(rows, uint16(x)^0x8000)
+		rows = binary.BigEndian.AppendUint32(rows, uint32(x+3000000000)^0x80000000)
+		rows = binary.BigEndian.AppendUint64(rows, (x+1<<63)^(1<<63))
+	}
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "14"), card("NAXIS2", "2"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "3"), card("TFORM1", "'I'"), card("TTYPE1", "'U'"), card("TZERO1", "32768"),
+		card("TFORM2", "'J'"), card("TTYPE2", "'V'"), card("TZERO2", "2147483648"),
+		card("TFORM3", "'K'"), card("TTYPE3", "'W'"), card("TZERO3", "9223372036854775808")}, rows))
+	h := fits[1]
+	if len(h.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", h.Warnings)
+	}
+	for row, x := range []uint64{60000, 0} {
+		if v := h.Field("U")(row); v != uint16(x) {
+			t.Errorf("U, row %d: got %#v, want uint16(%d)", row, v, x)
+		}
+		if v := h.Field("V")(row); v != uint32(x+3000000000) {
+			t.Errorf("V, row %d: got %#v, want uint32(%d)", row, v, x+3000000000)
+		}
+		if v := h.Field("W")(row); v != x+1<<63 {
+			t.Errorf("W, row %d: got %#v, want uint64(%d)", row, v, x+1<<63)
+		}
+	}
+	if p, err := h.ColumnInt64("U"); err != nil || p[0] != 60000 || p[1] != 0 {
+		t.Errorf("got %v (%v), want [60000 0]", p, err)
+	}
+}
+
+func TestAppendRowInvalid(t *testing.T) {
+	h, err := NewBinTable([]ColumnSpec{{Name: "N", Form: "J"}, {Name: "S", Form: "4A"}, {Name: "P", Form: "1PI"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.AppendRow(int32(1), "ab", []int16{5, 6, 7}); err != nil {
+		t.Fatal(err)
+	}
+	_, hasPcount := h.Keys["PCOUNT"]
+	_, hasTheap := h.Keys["THEAP"]
+	bad := [][]interface{}{
+		{nil, "x", []int16{}},
+		{int32(2), nil, []int16{}},
+		{int32(2), "x", nil},
+		{int32(2), "too long", []int16{}},
+		{int32(2), "x", []int32{1}},
+		{int32(2)},
+	}
+	for _, values := range bad {
+		if err := h.AppendRow(values...); err == nil {
+			t.Errorf("AppendRow(%v) should fail", values)
+		}
+		if h.Naxis[1] != 1 || h.Keys["NAXIS2"] != 1 || len(h.Data.([]byte)) != h.Naxis[0] {
+			t.Fatalf("AppendRow(%v) changed the table: NAXIS2=%v", values, h.Keys["NAXIS2"])
+		}
+		if _, ok := h.Keys["PCOUNT"]; ok != hasPcount {
+			t.Errorf("AppendRow(%v) changed the presence of PCOUNT", values)
+		}
+		if _, ok := h.Keys["THEAP"]; ok != hasTheap {
+			t.Errorf("AppendRow(%v) changed the presence of THEAP", values)
+		}
+	}
+	if x := h.Field("N")(0); x != int32(1) {
+		t.Errorf("got %#v, want int32(1)", x)
+	}
+}
+
+func TestBinaryFormRepeat(t *testing.T) {
+	for _, tc := range []struct {
+		form   string
+		repeat int
+	}{{"5E", 5}, {" 5E", 5}, {"E", 1}, {"0E", 0}, {"12J", 12}} {
+		code, repeat, err := binaryForm(tc.form)
+		if err != nil || code != 'E' && code != 'J' || repeat != tc.repeat {
+			t.Errorf("binaryForm(%q) = %c, %d, %v, want repeat %d", tc.form, code, repeat, err, tc.repeat)
+		}
+	}
+	for _, form := range []string{"-5E", "5 5E", "5", ""} {
+		if _, _, err := binaryForm(form); err == nil {
+			t.Errorf("binaryForm(%q) should fail", form)
+		}
+	}
+}
+
+func TestSignedByteField(t *testing.T) {
+	// the physical value is the stored value plus TZERO, so the stored 200 is read as 72 and -56 is stored as 72
+	fits := openBytes(t, binTable([]string{card("NAXIS1", "1"), card("NAXIS2", "3"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+		card("TFIELDS", "1"), card("TFORM1", "'B'"), card("TTYPE1", "'S'"), card("TZERO1", "-128")}, []byte{200, 72, 0}))
+	h := fits[1]
+	want := []int8{72, -56, -128}
+	for row, x := range want {
+		if v := h.Field("S")(row); v != x {
+			t.Errorf("row %d: got %#v, want int8(%d)", row, v, x)
+		}
+	}
+	if p, err := h.ColumnInt64("S"); err != nil || p[0] != 72 || p[1] != -56 || p[2] != -128 {
+		t.Errorf("got %v (%v), want %v", p, err, want)
+	}
+}
+
+func TestLowercaseTform(t *testing.T) {
+	var rows []byte
+	for _, x := range []float32{1.5, -2, 3, 4.25, 5} {
+		rows = binary.BigEndian.AppendUint32(rows, math.Float32bits(x))
+	}
+	rows = binary.BigEndian.AppendUint32(rows, uint32(0xfffffff9)) // -7
+	var values [2][]interface{}
+	for i, forms := range [][2]string{{"'5e'", "'j'"}, {"'5E'", "'J'"}} {
+		fits := openBytes(t, binTable([]string{card("NAXIS1", "24"), card("NAXIS2", "1"), card("PCOUNT", "0"), card("GCOUNT", "1"),
+			card("TFIELDS", "2"), card("TFORM1", forms[0]), card("TFORM2", forms[1])}, rows))
+		values[i] = []interface{}{fits[1].Field(0)(0), fits[1].Field(1)(0)}
+	}
+	if !reflect.DeepEqual(values[0], values[1]) {
+		t.Errorf("lowercase: got %#v, uppercase: got %#v", values[0], values[1])
+	}
+	if x := values[0][1]; x != int32(-7) {
+		t.Errorf("got %#v, want int32(-7)", x)
+	}
+}
+
+func TestVarArrayRoundTrip(t *testing.T) {
+	h, err := NewBinTable([]ColumnSpec{{Name: "N", Form: "J"}, {Name: "P", Form: "1PJ"}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]int32{{1, 2, 3}, {}, {-4, 5}}
+	for row := 0; row < 2; row++ {
+		if err := h.SetCell("N", row, int32(row)); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.SetCell("P", row, want[row]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.AppendRow(int32(2), want[2]); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Unit{h}); err != nil {
+		t.Fatal(err)
+	}
+	g := openBytes(t, buf.Bytes())[1]
+	if pcount := g.Keys["PCOUNT"]; pcount != 5*4 || pcount != h.Keys["PCOUNT"] {
+		t.Errorf("got PCOUNT = %v, want 20", pcount)
+	}
+	for row := range want {
+		if x, err := g.VarArray("P", row); err != nil || !reflect.DeepEqual(x, want[row]) {
+			t.Errorf("row %d: got %v (%v), want %v", row, x, err, want[row])
+		}
+	}
+
+	// SelectColumns copies the arrays of the selected fields to a new heap
+	s, err := g.SelectColumns("P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcount := s.Keys["PCOUNT"]; pcount != 5*4 {
+		t.Errorf("SelectColumns: got PCOUNT = %v, want 20", pcount)
+	}
+	for row := range want {
+		if x, err := s.VarArray("P", row); err != nil || !reflect.DeepEqual(x, want[row]) {
+			t.Errorf("SelectColumns, row %d: got %v (%v), want %v", row, x, err, want[row])
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the header of h against the FITS standard and returns all the problems found, or nil if h conforms
+// Unlike Open, which stops at the first fatal error, Validate collects every problem it finds. The checks include
+//
+//  1. The presence, types and values of the mandatory keys (SIMPLE or XTENSION, BITPIX, NAXIS, NAXISn, PCOUNT, GCOUNT
+//     and TFIELDS) and, for units read from a file, their order
+//  2. XTENSION-specific constraints: PCOUNT=0 and GCOUNT=1 for IMAGE, BITPIX=8, NAXIS=2 and GCOUNT=1 for tables
+//     and PCOUNT=0 for TABLE
+//  3. Table structure: a valid TFORMn for each of the TFIELDS fields (and none beyond), NAXIS1 equal to the sum
+//     of the field widths for BINTABLE, and increasing, non-overlapping TBCOLn within NAXIS1 for TABLE
+//
+// The errors are of the types defined in errors.go where applicable (e.g. ErrMissingKeyword)
+func (h *Unit) Validate() []error {
+	var errs []error
+	add := func(err error) {
+		errs = append(errs, err)
+	}
+	intKey := func(key string) (int, bool) {
+		x, ok := h.Keys[key].(int)
+		if !ok {
+			if _, present := h.Keys[key]; present {
+				add(ErrBadValue{key, fmt.Sprint(h.Keys[key])})
+			} else {
+				add(ErrMissingKeyword{key})
+			}
+		}
+		return x, ok
+	}
+
+	simple, primary := h.Keys["SIMPLE"]
+	xten, _ := h.Keys["XTENSION"].(string)
+	switch {
+	case primary && simple != true:
+		add(ErrBadValue{"SIMPLE", fmt.Sprint(simple)})
+	case !primary && xten == "":
+		add(ErrMissingKeyword{"SIMPLE or XTENSION"})
+	}
+
+	bitpix, bok := intKey("BITPIX")
+	if bok && typeName(bitpix) == "" {
+		add(ErrBadValue{"BITPIX", strconv.Itoa(bitpix)})
+	}
+	naxis, nok := intKey("NAXIS")
+	if nok && (naxis < 0 || naxis > 999) {
+		add(ErrBadValue{"NAXIS", strconv.Itoa(naxis)})
+	}
+	for i := 1; i <= naxis && i <= 999; i++ {
+		if n, ok := intKey(Nth("NAXIS", i)); ok && n < 0 {
+			add(ErrBadValue{Nth("NAXIS", i), strconv.Itoa(n)})
+		}
+	}
+	if len(h.order) > 0 {
+		if err := h.validateOrder(); err != nil {
+			add(err)
+		}
+	}
+	if primary {
+		return errs
+	}
+
+	pcount, pok := intKey("PCOUNT")
+	gcount, gok := intKey("GCOUNT")
+	switch xten {
+	case "IMAGE":
+		if pok && pcount != 0 {
+			add(ErrBadValue{"PCOUNT", strconv.Itoa(pcount)}) // PCOUNT should be 0 in IMAGE headers
+		}
+		if gok && gcount != 1 {
+			add(ErrBadValue{"GCOUNT", strconv.Itoa(gcount)}) // GCOUNT should be 1 in IMAGE headers
+		}
+	case "TABLE", "BINTABLE", "A3DTABLE":
+		if bok && bitpix != 8 {
+			add(ErrBadValue{"BITPIX", strconv.Itoa(bitpix)}) // BITPIX should be 8 in TABLE/BINTABLE headers
+		}
+		if nok && naxis != 2 {
+			add(ErrBadValue{"NAXIS", strconv.Itoa(naxis)}) // NAXIS should be 2 in TABLE/BINTABLE headers
+		}
+		if gok && gcount != 1 {
+			add(ErrBadValue{"GCOUNT", strconv.Itoa(gcount)})
+		}
+		if xten == "TABLE" && pok && pcount != 0 {
+			add(ErrBadValue{"PCOUNT", strconv.Itoa(pcount)}) // text tables have no heap
+		}
+		errs = append(errs, h.validateFields(xten == "TABLE")...)
+	}
+	return errs
+}
+
+// validateOrder checks that the mandatory keys are the first keys of the header in the order required by the standard
+// (see mandatoryKeys), based on the order of the keys in the file
+func (h *Unit) validateOrder() error {
+	want := h.mandatoryKeys()
+	if xten, _ := h.Keys["XTENSION"].(string); strings.HasSuffix(xten, "TABLE") && want[len(want)-1] != "TFIELDS" {
+		want = append(want, "TFIELDS")
+	}
+	for i, key := range want {
+		if i >= len(h.order) || h.order[i] != key {
+			return fmt.Errorf("Mandatory keyword %v should be at position %d of the header", key, i+1)
+		}
+	}
+	return nil
+}
+
+// validateFields is a helper function for Validate that checks the structure keys of the fields of a table
+func (h *Unit) validateFields(text bool) []error {
+	var errs []error
+	tfields, ok := h.Keys["TFIELDS"].(int)
+	if !ok {
+		return []error{ErrMissingKeyword{"TFIELDS"}}
+	}
+	if tfields < 0 || tfields > 999 {
+		return []error{ErrBadValue{"TFIELDS", strconv.Itoa(tfields)}}
+	}
+	naxis1, _ := h.Keys["NAXIS1"].(int)
+
+	width := 0 // the sum of the field widths (binary)
+	end := 0   // the end of the previous field (text)
+	for i := 1; i <= tfields; i++ {
+		form, ok := h.Keys[Nth("TFORM", i)].(string)
+		if !ok {
+			errs = append(errs, ErrMissingKeyword{Nth("TFORM", i)})
+			continue
+		}
+		if !text {
+			code, repeat, err := binaryForm(form)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			width += binarySize(code, repeat)
+			continue
+		}
+
+		var code rune
+		var w int
+		if n, _ := fmt.Sscanf(form, "%c%d", &code, &w); n != 2 || !strings.ContainsRune("AIFED", code) || w <= 0 {
+			errs = append(errs, ErrUnsupportedForm{form})
+			continue
+		}
+		tbcol, ok := h.Keys[Nth("TBCOL", i)].(int)
+		switch {
+		case !ok:
+			errs = append(errs, ErrMissingKeyword{Nth("TBCOL", i)})
+		case tbcol <= end:
+			errs = append(errs, fmt.Errorf("Field %d (TBCOL%d=%d) overlaps or precedes the previous field", i, i, tbcol))
+		case tbcol-1+w > naxis1:
+			errs = append(errs, fmt.Errorf("Field %d (TBCOL%d=%d, TFORM%d=%v) does not fit in NAXIS1=%d", i, i, tbcol, i, form, naxis1))
+		}
+		if ok {
+			end = tbcol - 1 + w
+		}
+	}
+	if !text && width != naxis1 {
+		errs = append(errs, fmt.Errorf("NAXIS1=%d is not equal to the sum of the field widths (%d)", naxis1, width))
+	}
+
+	for i := tfields + 1; i <= 999; i++ { // TFORMn beyond TFIELDS
+		if _, ok := h.Keys[Nth("TFORM", i)]; ok {
+			errs = append(errs, fmt.Errorf("%v is defined, but TFIELDS=%d", Nth("TFORM", i), tfields))
+		}
+	}
+	return errs
+}
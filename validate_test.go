@@ -0,0 +1,29 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateMissingKeys(t *testing.T) {
+	h := newTable(t, 1)
+	delete(h.Keys, "BITPIX")
+	delete(h.Keys, "NAXIS")
+	errs := h.Validate()
+	for _, key := range []string{"BITPIX", "NAXIS"} {
+		n := 0
+		for _, err := range errs {
+			var missing ErrMissingKeyword
+			var bad ErrBadValue
+			if errors.As(err, &missing) && missing.Key == key || errors.As(err, &bad) && bad.Key == key {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("%s is reported %d times: %v", key, n, errs)
+		}
+	}
+}
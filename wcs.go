@@ -0,0 +1,379 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+//
+// This file provides access to the World Coordinate System (WCS) keys as described in
+//  Greisen E. W., Calabretta M. R. Representations of world coordinates in FITS. A&A 395, 1061 (2002)
+
+package fits
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// WCS holds the linear part of a WCS description of an image as read from the header by WCS or WCSAlt
+// Crpix, Crval and Ctype hold CRPIXn, CRVALn and CTYPEn for each axis (n = 1...Axes)
+// CD is the Axes x Axes transformation matrix in row-major order, i.e. CD[(i-1)*Axes+(j-1)] is CDi_j
+type WCS struct {
+	Alt   byte      // The letter that identifies an alternate description (A-Z), 0 for the primary description
+	Axes  int       // The number of WCS axes (WCSAXES, defaults to NAXIS)
+	Crpix []float64 // The reference pixel
+	Crval []float64 // The world coordinates of the reference pixel
+	CD    []float64 // The transformation matrix
+	Ctype []string  // The axis types, e.g. RA---TAN
+	// LonPole is LONPOLE, the native longitude of the celestial pole in degrees, used by PixToWorld and WorldToPix
+	// It defaults to 180, or 0 if the reference point is the celestial pole (CRVAL of the latitude axis is 90)
+	LonPole float64
+}
+
+// WCSMatrix returns the linear part of the WCS transformation of an image as read from the header
+// crpix, crval and ctype hold CRPIXn, CRVALn and CTYPEn for each axis (n = 1...WCSAXES)
+// cd is the WCSAXES x WCSAXES transformation matrix in row-major order, i.e. cd[(i-1)*WCSAXES+(j-1)] is CDi_j
+// The number of WCS axes is given by WCSAXES, which can be larger than NAXIS (e.g. a 2D image with a spectral third
+// axis of length 1), and defaults to NAXIS
+// If the header has no CDi_j keys, the matrix is calculated as PCi_j * CDELTi (PCi_j defaults to the identity matrix)
+// The projection itself (e.g. the TAN in RA---TAN) is not applied; the coefficients are meant to be passed to a WCS library
+// An error naming the missing keys is returned if any of the required keys is absent
+func (h *Unit) WCSMatrix() (crpix, crval, cd []float64, ctype []string, err error) {
+	w, err := h.WCS()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return w.Crpix, w.Crval, w.CD, w.Ctype, nil
+}
+
+// WCS returns the primary WCS description of an image (the keys without a letter suffix), see WCSMatrix
+func (h *Unit) WCS() (*WCS, error) {
+	return h.wcs(0)
+}
+
+// WCSAlt returns the alternate WCS description identified by letter (A-Z), which is read from the keys with the letter
+// suffix, e.g. CRVAL1A and CD1_1A for WCSAlt('A'). Files may carry more than one description, e.g. a fitted and a nominal solution
+// An error is returned if the header has no description with this letter
+func (h *Unit) WCSAlt(letter byte) (*WCS, error) {
+	if letter < 'A' || letter > 'Z' {
+		return nil, fmt.Errorf("Invalid alternate WCS letter %q", letter)
+	}
+	found := false
+	for _, key := range []string{"WCSAXES", "WCSNAME", "CRPIX1", "CRVAL1", "CTYPE1"} {
+		if _, ok := h.Keys[key+string(letter)]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Alternate WCS %c not found", letter)
+	}
+	return h.wcs(letter)
+}
+
+// wcs is the helper function for WCS and WCSAlt that reads the description identified by alt (0 for the primary one)
+func (h *Unit) wcs(alt byte) (*WCS, error) {
+	suffix := ""
+	if alt != 0 {
+		suffix = string(alt)
+	}
+	key := func(prefix string, i int) string { // e.g. CRPIX2A
+		return Nth(prefix, i) + suffix
+	}
+
+	n := len(h.Naxis)
+	if wcsaxes, ok := h.Keys["WCSAXES"+suffix].(int); ok && wcsaxes > 0 {
+		n = wcsaxes
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("WCS needs an image")
+	}
+	w := &WCS{
+		Alt:   alt,
+		Axes:  n,
+		Crpix: make([]float64, n),
+		Crval: make([]float64, n),
+		CD:    make([]float64, n*n),
+		Ctype: make([]string, n),
+	}
+
+	var missing []string
+	for i := 0; i < n; i++ {
+		var ok bool
+		if w.Crpix[i], ok = h.floatKey(key("CRPIX", i+1)); !ok {
+			missing = append(missing, key("CRPIX", i+1))
+		}
+		if w.Crval[i], ok = h.floatKey(key("CRVAL", i+1)); !ok {
+			missing = append(missing, key("CRVAL", i+1))
+		}
+		if w.Ctype[i], ok = h.Keys[key("CTYPE", i+1)].(string); !ok {
+			missing = append(missing, key("CTYPE", i+1))
+		}
+	}
+
+	if h.hasCD(suffix) {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				w.CD[i*n+j], _ = h.floatKey(fmt.Sprintf("CD%d_%d%s", i+1, j+1, suffix)) // missing CDi_j keys default to 0
+			}
+		}
+	} else {
+		for i := 0; i < n; i++ {
+			cdelt, ok := h.floatKey(key("CDELT", i+1))
+			if !ok {
+				missing = append(missing, key("CDELT", i+1)+" (or CD"+fmt.Sprint(i+1)+"_j"+suffix+")")
+			}
+			for j := 0; j < n; j++ {
+				pc, ok := h.floatKey(fmt.Sprintf("PC%d_%d%s", i+1, j+1, suffix))
+				if !ok && i == j {
+					pc = 1
+				}
+				w.CD[i*n+j] = pc * cdelt
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Missing WCS keys: %s", strings.Join(missing, ", "))
+	}
+
+	var ok bool
+	if w.LonPole, ok = h.floatKey("LONPOLE" + suffix); !ok {
+		w.LonPole = 180
+		if _, lat, _, err := w.celestial(); err == nil && lat != -1 && w.Crval[lat] >= 90 {
+			w.LonPole = 0
+		}
+	}
+	return w, nil
+}
+
+// celestial returns the indices of the celestial longitude and latitude axes of w (e.g. RA---TAN and DEC--TAN) and the
+// projection code, or -1, -1 if w has no celestial axes. An error is returned if only one of the pair is present
+func (w *WCS) celestial() (lon, lat int, proj string, err error) {
+	lon, lat = -1, -1
+	for i, ctype := range w.Ctype {
+		c := strings.ToUpper(ctype) + "        "
+		switch {
+		case c[:4] == "RA--" || strings.HasSuffix(c[:4], "LON"):
+			lon = i
+		case c[:4] == "DEC-" || strings.HasSuffix(c[:4], "LAT"):
+			lat = i
+		default:
+			continue
+		}
+		proj = strings.TrimSpace(c[5:8])
+	}
+	if (lon == -1) != (lat == -1) {
+		return -1, -1, "", fmt.Errorf("Celestial axes should come in pairs (e.g. RA and DEC): %v", w.Ctype)
+	}
+	return lon, lat, proj, nil
+}
+
+// PixToWorld converts the pixel coordinates pix (0-based as in At, pix[k] is along NAXIS{k+1}) to world coordinates
+// The linear transformation (CRPIX, CD and CRVAL) is applied to all the axes, and the celestial axes are deprojected
+// Only the gnomonic (TAN) projection is supported; celestial coordinates are in degrees
+func (w *WCS) PixToWorld(pix []float64) ([]float64, error) {
+	if len(pix) != w.Axes {
+		return nil, fmt.Errorf("Expected %d coordinates, got %d", w.Axes, len(pix))
+	}
+	lon, lat, proj, err := w.celestial()
+	if err != nil {
+		return nil, err
+	}
+	if lon != -1 && proj != "TAN" {
+		return nil, fmt.Errorf("Unsupported projection: %v", proj)
+	}
+
+	n := w.Axes
+	world := make([]float64, n)
+	for i := 0; i < n; i++ { // the intermediate world coordinates
+		for j := 0; j < n; j++ {
+			world[i] += w.CD[i*n+j] * (pix[j] + 1 - w.Crpix[j]) // FITS pixel coordinates are 1-based
+		}
+	}
+	for i := range world {
+		if i != lon && i != lat {
+			world[i] += w.Crval[i]
+		}
+	}
+	if lon != -1 {
+		x, y := world[lon], world[lat]
+		phi := math.Atan2(x, -y)                           // native longitude
+		theta := math.Atan2(180/math.Pi, math.Hypot(x, y)) // native latitude (TAN)
+		world[lon], world[lat] = nativeToCelestial(phi, theta, w.Crval[lon]*deg, w.Crval[lat]*deg, w.LonPole*deg)
+	}
+	return world, nil
+}
+
+// WorldToPix is the inverse of PixToWorld: it converts world coordinates to 0-based pixel coordinates
+// An error is returned if a celestial position is on the far side of the projection (more than 90 degrees from CRVAL)
+func (w *WCS) WorldToPix(world []float64) ([]float64, error) {
+	if len(world) != w.Axes {
+		return nil, fmt.Errorf("Expected %d coordinates, got %d", w.Axes, len(world))
+	}
+	lon, lat, proj, err := w.celestial()
+	if err != nil {
+		return nil, err
+	}
+	if lon != -1 && proj != "TAN" {
+		return nil, fmt.Errorf("Unsupported projection: %v", proj)
+	}
+	inv, err := invert(w.CD, w.Axes)
+	if err != nil {
+		return nil, err
+	}
+
+	n := w.Axes
+	x := make([]float64, n) // the intermediate world coordinates
+	for i := range x {
+		x[i] = world[i] - w.Crval[i]
+	}
+	if lon != -1 {
+		phi, theta := celestialToNative(world[lon]*deg, world[lat]*deg, w.Crval[lon]*deg, w.Crval[lat]*deg, w.LonPole*deg)
+		if theta <= 0 {
+			return nil, fmt.Errorf("Position (%v, %v) cannot be projected", world[lon], world[lat])
+		}
+		r := 180 / math.Pi / math.Tan(theta)
+		x[lon], x[lat] = r*math.Sin(phi), -r*math.Cos(phi)
+	}
+	pix := make([]float64, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			pix[i] += inv[i*n+j] * x[j]
+		}
+		pix[i] += w.Crpix[i] - 1
+	}
+	return pix, nil
+}
+
+// deg converts degrees to radians
+const deg = math.Pi / 180
+
+// nativeToCelestial rotates the native spherical coordinates (phi, theta) to the celestial coordinates (in degrees) given
+// the celestial coordinates of the reference point (a0, d0) and the native longitude of the celestial pole (phip), all in radians
+// The latitude is calculated by atan2 of its sine and cosine, since asin loses precision near the poles (e.g. close to the
+// reference point of a zenithal projection)
+func nativeToCelestial(phi, theta, a0, d0, phip float64) (a, d float64) {
+	y := -math.Cos(theta) * math.Sin(phi-phip)
+	x := math.Sin(theta)*math.Cos(d0) - math.Cos(theta)*math.Sin(d0)*math.Cos(phi-phip)
+	a = a0 + math.Atan2(y, x)
+	d = math.Atan2(math.Sin(theta)*math.Sin(d0)+math.Cos(theta)*math.Cos(d0)*math.Cos(phi-phip), math.Hypot(x, y))
+	a = math.Mod(a/deg+360, 360)
+	return a, d / deg
+}
+
+// celestialToNative is the inverse of nativeToCelestial, a and d are in radians and the result is in radians
+func celestialToNative(a, d, a0, d0, phip float64) (phi, theta float64) {
+	y := -math.Cos(d) * math.Sin(a-a0)
+	x := math.Sin(d)*math.Cos(d0) - math.Cos(d)*math.Sin(d0)*math.Cos(a-a0)
+	phi = phip + math.Atan2(y, x)
+	theta = math.Atan2(math.Sin(d)*math.Sin(d0)+math.Cos(d)*math.Cos(d0)*math.Cos(a-a0), math.Hypot(x, y))
+	return phi, theta
+}
+
+// invert returns the inverse of the n x n matrix m (row-major) calculated by Gauss-Jordan elimination with partial pivoting
+func invert(m []float64, n int) ([]float64, error) {
+	a := append([]float64(nil), m...)
+	inv := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		inv[i*n+i] = 1
+	}
+	for c := 0; c < n; c++ {
+		p := c // the pivot row
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r*n+c]) > math.Abs(a[p*n+c]) {
+				p = r
+			}
+		}
+		if a[p*n+c] == 0 {
+			return nil, fmt.Errorf("The WCS transformation matrix is singular")
+		}
+		for k := 0; k < n; k++ {
+			a[c*n+k], a[p*n+k] = a[p*n+k], a[c*n+k]
+			inv[c*n+k], inv[p*n+k] = inv[p*n+k], inv[c*n+k]
+		}
+		f := a[c*n+c]
+		for k := 0; k < n; k++ {
+			a[c*n+k] /= f
+			inv[c*n+k] /= f
+		}
+		for r := 0; r < n; r++ {
+			if r != c && a[r*n+c] != 0 {
+				f := a[r*n+c]
+				for k := 0; k < n; k++ {
+					a[r*n+k] -= f * a[c*n+k]
+					inv[r*n+k] -= f * inv[c*n+k]
+				}
+			}
+		}
+	}
+	return inv, nil
+}
+
+// epsPixel is the tolerance of Reproject for the pixels on the edges of the source image, as the round trip of PixToWorld
+// and WorldToPix is not exact (e.g. a pixel on the left edge may be mapped back to x=-1e-12)
+const epsPixel = 1e-6
+
+// Reproject resamples the image of h onto the pixel grid of target, e.g. to align two images of the same field before
+// combining them. For each pixel of target, its world coordinates are calculated by the WCS of target and mapped back
+// to a (fractional) pixel of h by the WCS of h, where the physical value of h is sampled by bilinear interpolation
+// Both units should be two-dimensional images with a celestial WCS (see PixToWorld). Only the header of target is used
+// The result is a float64 image with the header of target (see derive). The pixels that fall outside h, or next to
+// a blank pixel of h, are NaN
+func (h *Unit) Reproject(target *Unit) (*Unit, error) {
+	if !h.HasImage() || len(h.Naxis) != 2 || len(target.Naxis) != 2 {
+		return nil, fmt.Errorf("Reproject needs two-dimensional images")
+	}
+	src, err := h.WCS()
+	if err != nil {
+		return nil, err
+	}
+	dst, err := target.WCS()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range []*WCS{src, dst} {
+		if lon, _, _, err := w.celestial(); err != nil || lon == -1 || w.Axes != 2 {
+			return nil, fmt.Errorf("Reproject needs a two-dimensional celestial WCS: %v", w.Ctype)
+		}
+	}
+
+	values := h.floats()
+	nx, ny := h.Naxis[0], h.Naxis[1]
+	sample := func(x, y int) float64 {
+		return values[y*nx+x]
+	}
+	data := make([]float64, product(target.Naxis))
+	for i := range data {
+		data[i] = math.NaN()
+		world, err := dst.PixToWorld([]float64{float64(i % target.Naxis[0]), float64(i / target.Naxis[0])})
+		if err != nil {
+			return nil, err
+		}
+		p, err := src.WorldToPix(world)
+		if err != nil || !(p[0] >= -epsPixel && p[0] <= float64(nx-1)+epsPixel && p[1] >= -epsPixel && p[1] <= float64(ny-1)+epsPixel) {
+			continue // outside h
+		}
+		p[0] = math.Min(math.Max(p[0], 0), float64(nx-1)) // the pixels on the edges are within epsPixel
+		p[1] = math.Min(math.Max(p[1], 0), float64(ny-1))
+		x0, y0 := int(p[0]), int(p[1])
+		x1, y1 := x0+1, y0+1
+		if x1 == nx {
+			x1 = x0
+		}
+		if y1 == ny {
+			y1 = y0
+		}
+		fx, fy := p[0]-float64(x0), p[1]-float64(y0)
+		data[i] = (1-fx)*(1-fy)*sample(x0, y0) + fx*(1-fy)*sample(x1, y0) + (1-fx)*fy*sample(x0, y1) + fx*fy*sample(x1, y1)
+	}
+	return target.derive(target.Naxis, data), nil
+}
+
+// hasCD returns true if the header has any CDi_j key with the given suffix (the alternate WCS letter or "")
+func (h *Unit) hasCD(suffix string) bool {
+	for key := range h.Keys {
+		var i, j int
+		var rest string
+		if n, _ := fmt.Sscanf(key, "CD%d_%d%s", &i, &j, &rest); n >= 2 && rest == suffix {
+			return true
+		}
+	}
+	return false
+}
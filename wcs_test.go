@@ -0,0 +1,73 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWCSAxes(t *testing.T) {
+	// a 2D image with a third (spectral) WCS axis declared by WCSAXES=3
+	h := image16(t, []string{card("WCSAXES", "3"),
+		card("CRPIX1", "1.0"), card("CRVAL1", "10.0"), card("CDELT1", "2.0"), card("CTYPE1", "'RA---TAN'"),
+		card("CRPIX2", "1.0"), card("CRVAL2", "20.0"), card("CDELT2", "2.0"), card("CTYPE2", "'DEC--TAN'"),
+		card("CRPIX3", "1.0"), card("CRVAL3", "1.4E9"), card("CDELT3", "1.0E6"), card("CTYPE3", "'FREQ'")}, 1, 2, 3, 4)
+	crpix, crval, cd, ctype, err := h.WCSMatrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crpix) != 3 || len(crval) != 3 || len(cd) != 9 || len(ctype) != 3 {
+		t.Fatalf("expected 3 WCS axes, got crpix=%v, cd=%v", crpix, cd)
+	}
+	if crval[2] != 1.4e9 || ctype[2] != "FREQ" || cd[8] != 1e6 || cd[0] != 2 || cd[2] != 0 || cd[6] != 0 {
+		t.Errorf("got crval=%v, ctype=%v, cd=%v", crval, ctype, cd)
+	}
+
+	delete(h.Keys, "WCSAXES") // the number of WCS axes defaults to NAXIS
+	if crpix, _, cd, _, err = h.WCSMatrix(); err != nil || len(crpix) != 2 || len(cd) != 4 {
+		t.Errorf("expected 2 WCS axes, got crpix=%v, cd=%v (%v)", crpix, cd, err)
+	}
+}
+
+// tanImage returns a 2x2 image with a TAN projection centered on its first pixel, with 3.6" pixels at declination dec
+func tanImage(t *testing.T, dec string) *Unit {
+	return image16(t, []string{card("CRPIX1", "1.0"), card("CRVAL1", "10.0"), card("CDELT1", "-0.001"), card("CTYPE1", "'RA---TAN'"),
+		card("CRPIX2", "1.0"), card("CRVAL2", dec), card("CDELT2", "0.001"), card("CTYPE2", "'DEC--TAN'")}, 1, 2, 3, 4)
+}
+
+func TestWCSRoundTrip(t *testing.T) {
+	for _, dec := range []string{"20.0", "-45.0", "89.9", "0.0"} {
+		w, err := tanImage(t, dec).WCS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pix := range [][]float64{{0, 0}, {1, 0}, {0, 1}, {1e-9, -1e-9}, {-250.5, 731.25}} {
+			world, err := w.PixToWorld(pix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := w.WorldToPix(world)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(p[0]-pix[0]) > 1e-8 || math.Abs(p[1]-pix[1]) > 1e-8 {
+				t.Errorf("CRVAL2=%v: %v -> %v -> %v", dec, pix, world, p)
+			}
+		}
+	}
+}
+
+func TestReprojectIdentity(t *testing.T) {
+	h := tanImage(t, "20.0")
+	g, err := h.Reproject(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, x := range g.Data.([]float64) {
+		if want := float64(i + 1); math.Abs(x-want) > 1e-6 {
+			t.Errorf("pixel %d: got %v, want %v", i, x, want)
+		}
+	}
+}
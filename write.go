@@ -0,0 +1,758 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Write writes units to w as a FITS file
+// Each unit is written as a header generated from Keys followed by its data
+// Both the header and the data are padded to a multiple of 2880 bytes (the FITS block size)
+// The first unit is written as the primary HDU and the rest as extensions, converting them if needed (see asHDU): for example,
+// a primary HDU written in a later position becomes an IMAGE extension. If the first unit is a table, an empty primary HDU
+// is written before it. The units themselves are not modified
+func Write(w io.Writer, units []*Unit) error {
+	if len(units) > 0 && units[0].HasTable() {
+		empty := &Unit{Keys: map[string]interface{}{"SIMPLE": true, "BITPIX": 8, "NAXIS": 0, "EXTEND": true}}
+		units = append([]*Unit{empty}, units...)
+	}
+	for i, h := range units {
+		if err := h.asHDU(i == 0).writeHDU(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHDU writes the header of h followed by its data to w, both padded to a multiple of 2880 bytes
+func (h *Unit) writeHDU(w io.Writer) error {
+	header, err := h.encodeHeader()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(h.paddedData())
+	return err
+}
+
+// asHDU returns h, or a copy of h with the keys converted if needed, to be written as the primary HDU (if primary is true)
+// or as an extension. A primary HDU gets SIMPLE=T instead of XTENSION, PCOUNT and GCOUNT, and an extension gets XTENSION
+// (IMAGE unless h is a table), PCOUNT and GCOUNT instead of SIMPLE and EXTEND
+// The copy shares Data with h. CHECKSUM is removed from the converted header, as it is no longer valid
+func (h *Unit) asHDU(primary bool) *Unit {
+	_, simple := h.Keys["SIMPLE"]
+	_, xten := h.Keys["XTENSION"]
+	if (primary && simple) || (!primary && xten) {
+		return h
+	}
+
+	keys := copyKeys(h.Keys)
+	delete(keys, "CHECKSUM")
+	if primary {
+		delete(keys, "XTENSION")
+		delete(keys, "PCOUNT")
+		delete(keys, "GCOUNT")
+		keys["SIMPLE"] = true
+	} else {
+		delete(keys, "SIMPLE")
+		delete(keys, "EXTEND")
+		keys["XTENSION"] = "IMAGE"
+		if h.HasTable() {
+			keys["XTENSION"] = h.class
+		}
+		if _, ok := keys["PCOUNT"]; !ok {
+			keys["PCOUNT"] = len(h.heap)
+		}
+		if _, ok := keys["GCOUNT"]; !ok {
+			keys["GCOUNT"] = 1
+		}
+	}
+	return &Unit{
+		Keys:       keys,
+		Naxis:      h.Naxis,
+		Data:       h.Data,
+		BlankCards: h.BlankCards,
+		History:    h.History,
+		Comments:   h.Comments,
+		class:      h.class,
+		heap:       h.heap,
+		order:      append([]string(nil), h.order...),
+		tokens:     h.tokens,
+		defaults:   h.defaults,
+	}
+}
+
+// WriteTable writes h, which should be a TABLE or BINTABLE unit, to w as a header followed by the table data
+// Both are padded to a multiple of 2880 bytes, so WriteTable can be used to append a table extension to a FITS file
+// Before writing, WriteTable verifies that TFIELDS, TFORMn and NAXIS1 are consistent with each other and with Data
+func (h *Unit) WriteTable(w io.Writer) error {
+	if err := h.verifyTable(); err != nil {
+		return err
+	}
+	return h.asHDU(false).writeHDU(w)
+}
+
+// verifyTable checks the consistency of the table structure keys (TFIELDS, TFORMn, TBCOLn and NAXISn) and the table data
+func (h *Unit) verifyTable() error {
+	xten, _ := h.Keys["XTENSION"].(string)
+	if xten == "A3DTABLE" { // the legacy name of BINTABLE
+		xten = "BINTABLE"
+	}
+	if xten != "TABLE" && xten != "BINTABLE" {
+		return fmt.Errorf("Unit is not a TABLE or BINTABLE")
+	}
+	naxis1, ok1 := h.Keys["NAXIS1"].(int)
+	naxis2, ok2 := h.Keys["NAXIS2"].(int)
+	if !ok1 || !ok2 {
+		return ErrMissingKeyword{"NAXIS1/NAXIS2"}
+	}
+	data, ok := h.Data.([]byte)
+	if !ok || len(data) != naxis1*naxis2 {
+		return fmt.Errorf("Table data size does not match NAXIS1 x NAXIS2")
+	}
+	tfields, ok := h.Keys["TFIELDS"].(int)
+	if !ok {
+		return ErrMissingKeyword{"TFIELDS"}
+	}
+
+	width := 0 // the sum of the field widths (binary) or the end of the rightmost field (text)
+	for i := 1; i <= tfields; i++ {
+		form, ok := h.Keys[Nth("TFORM", i)].(string)
+		if !ok {
+			return ErrMissingKeyword{Nth("TFORM", i)}
+		}
+		if xten == "BINTABLE" {
+			code, repeat, err := binaryForm(form)
+			if err != nil {
+				return err
+			}
+			width += binarySize(code, repeat)
+		} else {
+			tbcol, ok := h.Keys[Nth("TBCOL", i)].(int)
+			if !ok {
+				return ErrMissingKeyword{Nth("TBCOL", i)}
+			}
+			var code rune
+			var w int
+			if n, _ := fmt.Sscanf(form, "%c%d", &code, &w); n != 2 || !strings.ContainsRune("AIFED", unicode.ToUpper(code)) {
+				return ErrUnsupportedForm{form}
+			}
+			if end := tbcol - 1 + w; end > width {
+				width = end
+			}
+		}
+	}
+	if width > naxis1 {
+		return ErrBadValue{"NAXIS1", strconv.Itoa(naxis1)} // the fields do not fit in a row
+	}
+	if pcount, _ := h.Keys["PCOUNT"].(int); xten == "BINTABLE" && h.heap != nil && pcount != len(h.heap) {
+		return ErrBadValue{"PCOUNT", strconv.Itoa(pcount)} // PCOUNT should be equal to the size of the heap
+	}
+	return nil
+}
+
+// headerKeys returns the list of keys to be written in the header
+// The mandatory keys come first in the order required by the standard, followed by the rest of the keys in the order they
+// appear in the header (see Unit.order), and then the keys not present in the original header in alphabetical order
+// END is not included
+func (h *Unit) headerKeys() []string {
+	keys := h.mandatoryKeys()
+	done := make(map[string]bool, len(keys)) // the keys that are already in the list
+	for _, key := range keys {
+		done[key] = true
+	}
+
+	for _, key := range h.order {
+		if _, ok := h.Keys[key]; ok && !done[key] && key != "END" {
+			keys = append(keys, key)
+			done[key] = true
+		}
+	}
+	for key := range h.defaults { // the keys added by buildTable are not part of the header
+		if h.isDefault(key) {
+			done[key] = true
+		}
+	}
+
+	return append(keys, h.otherKeys(done)...)
+}
+
+// SortedKeys returns the keys in the header in a deterministic order, e.g. to print a header
+// The mandatory keys come first in the order required by the standard, followed by the rest of the keys in alphabetical order
+// END and the mandatory keys that are missing from Keys are not included
+func (h *Unit) SortedKeys() []string {
+	var keys []string
+	done := make(map[string]bool)
+	for _, key := range h.mandatoryKeys() {
+		if _, ok := h.Keys[key]; ok {
+			keys = append(keys, key)
+		}
+		done[key] = true
+	}
+	return append(keys, h.otherKeys(done)...)
+}
+
+// otherKeys returns the keys in the header that are not in done in alphabetical order
+// END is not included
+func (h *Unit) otherKeys(done map[string]bool) []string {
+	var rest []string
+	for key := range h.Keys {
+		if !done[key] && key != "END" {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return rest
+}
+
+// mandatoryKeys returns the list of the mandatory keys of h in the order required by the standard
+// (SIMPLE or XTENSION, BITPIX, NAXIS, NAXIS1...NAXISn, PCOUNT, GCOUNT and TFIELDS)
+func (h *Unit) mandatoryKeys() []string {
+	var keys []string
+	if _, ok := h.Keys["SIMPLE"]; ok {
+		keys = append(keys, "SIMPLE")
+	} else {
+		keys = append(keys, "XTENSION")
+	}
+	keys = append(keys, "BITPIX", "NAXIS")
+	naxis, _ := h.Keys["NAXIS"].(int)
+	for i := 1; i <= naxis; i++ {
+		keys = append(keys, Nth("NAXIS", i))
+	}
+	if keys[0] == "XTENSION" {
+		keys = append(keys, "PCOUNT", "GCOUNT")
+	}
+	if _, ok := h.Keys["TFIELDS"]; ok {
+		keys = append(keys, "TFIELDS")
+	}
+	return keys
+}
+
+// Card is a header card (a line of the header) holding a key and its value
+// Value is nil for the keys without a value (e.g. COMMENT, HISTORY or END)
+type Card struct {
+	Key   string
+	Value interface{}
+}
+
+// OrderedCards returns the header of h as a list of cards in the standard order, as written by Write
+// The mandatory keys come first in the order required by the standard (SIMPLE or XTENSION, BITPIX, NAXIS, NAXIS1...NAXISn,
+// PCOUNT, GCOUNT and TFIELDS), followed by the rest of the keys in the order they appear in the header
+// The keys added after reading the header come next in alphabetical order and the last card is END
+// The mandatory keys missing from Keys are skipped
+func (h *Unit) OrderedCards() []Card {
+	var cards []Card
+	for _, key := range h.headerKeys() {
+		if value, ok := h.Keys[key]; ok {
+			cards = append(cards, Card{key, value})
+		}
+	}
+	return append(cards, Card{Key: "END"})
+}
+
+// encodeHeader generates the header blocks of h based on Keys
+// HISTORY is written as one card per line of History (if not empty) in place of the HISTORY key, or after the keys if there
+// is no HISTORY key (e.g. for a unit created by NewBinTable), and the same for COMMENT and Comments. The blank cards
+// (BlankCards) are written after the keys
+// The result is terminated by END and is padded with spaces to a multiple of 2880 bytes
+func (h *Unit) encodeHeader() ([]byte, error) {
+	var buf bytes.Buffer
+	commentary := map[string][]string{"HISTORY": h.History, "COMMENT": h.Comments}
+	writeCommentary := func(key string) error {
+		for _, text := range commentary[key] {
+			card, err := FormatCard(key, nil, text)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(card)
+		}
+		return nil
+	}
+	for _, key := range h.headerKeys() {
+		value, ok := h.Keys[key]
+		if !ok {
+			return nil, fmt.Errorf("Mandatory key %v is missing", key)
+		}
+		if len(commentary[key]) > 0 {
+			if err := writeCommentary(key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if token, ok := h.specialToken(key, value); ok { // NaN or Inf as read from the file (see specialFloat)
+			buf.WriteString(fmt.Sprintf("%-8s= %20s%50s", key, token, ""))
+			continue
+		}
+		card, err := FormatCard(key, value, "")
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(card)
+	}
+	for _, key := range []string{"HISTORY", "COMMENT"} {
+		if _, ok := h.Keys[key]; !ok {
+			if err := writeCommentary(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, text := range h.BlankCards {
+		if len(text) > 72 {
+			return nil, fmt.Errorf("Blank card is longer than 72 characters")
+		}
+		buf.WriteString(fmt.Sprintf("        %-72s", text))
+	}
+	buf.WriteString(fmt.Sprintf("%-80s", "END"))
+	for buf.Len()%2880 != 0 {
+		buf.WriteByte(' ')
+	}
+	return buf.Bytes(), nil
+}
+
+// specialToken returns the original text of the value of key if it was read as NaN or Inf (see specialFloat) and is unchanged
+// The text is right-justified to column 30 as a number, so it is read back as the same value
+func (h *Unit) specialToken(key string, value interface{}) (string, bool) {
+	token, ok := h.tokens[key]
+	if !ok || len(token) > 20 {
+		return "", false
+	}
+	x, isFloat := value.(float64)
+	y, _ := specialFloat(token)
+	return token, isFloat && (x == y || math.IsNaN(x) && math.IsNaN(y))
+}
+
+// FormatCard generates an 80-byte header card (a line of the header) for the given key, value and comment
+// The key is left-justified in columns 1-8 and is followed by "= " in columns 9-10. The value is formatted based on its type:
+// strings are quoted (padded to at least 8 characters) and left-justified starting in column 11, and
+// logical and numerical values are right-justified to column 30 (fixed format)
+// The comment, if not empty, is appended after " / "
+// A nil value generates a commentary card (e.g. COMMENT or HISTORY) with the comment as its text in columns 9-80
+// Keys starting with "HIERARCH " (as read by NewHeader) are written as HIERARCH cards, e.g. HIERARCH ESO DET CHIP ID = 'CCD1'
+// Strings that do not fit in one card are split over CONTINUE cards (the long-string convention), so the result may hold
+// more than one card (a multiple of 80 characters)
+// An error is returned if the key is longer than 8 characters (except for HIERARCH keys) or the card does not fit in 80 columns
+func FormatCard(key string, value interface{}, comment string) (string, error) {
+	var s string
+	lhs := fmt.Sprintf("%-8s= ", key) // the key and the value indicator
+	hierarch := strings.HasPrefix(key, "HIERARCH ")
+	if hierarch {
+		lhs = key + " = "
+	}
+
+	switch x := value.(type) {
+	case nil:
+		s = key
+		if comment != "" {
+			s = fmt.Sprintf("%-8s%s", key, comment)
+		}
+	case string:
+		q := strings.Replace(x, "'", "''", -1)
+		s = fmt.Sprintf("%s'%-8s'", lhs, q)
+		if len(s) > 80 {
+			return longString(lhs, q, comment)
+		}
+	case bool:
+		v := "F"
+		if x {
+			v = "T"
+		}
+		s = fmt.Sprintf("%s%20s", lhs, v)
+	case int:
+		s = fmt.Sprintf("%s%20d", lhs, x)
+	case float64:
+		v, err := formatFloat(x)
+		if err != nil {
+			return "", fmt.Errorf("Invalid value for %v: %v", key, err)
+		}
+		s = fmt.Sprintf("%s%20s", lhs, v)
+	case complex128:
+		re, err := formatFloat(real(x))
+		if err != nil {
+			return "", fmt.Errorf("Invalid value for %v: %v", key, err)
+		}
+		im, err := formatFloat(imag(x))
+		if err != nil {
+			return "", fmt.Errorf("Invalid value for %v: %v", key, err)
+		}
+		s = fmt.Sprintf("%s%20s", lhs, "("+re+","+im+")")
+	default:
+		return "", fmt.Errorf("Unsupported type %T for %v", value, key)
+	}
+
+	if value != nil && comment != "" {
+		s += " / " + comment
+	}
+	if (len(key) > 8 && !hierarch) || len(s) > 80 {
+		return "", fmt.Errorf("Key, value or comment is too long to fit in a card: %v", key)
+	}
+	return fmt.Sprintf("%-80s", s), nil
+}
+
+// longString is a helper function for FormatCard that splits a string value (q, with the quotes already doubled) over a card
+// starting with lhs and as many CONTINUE cards as needed. Each part except the last one ends with '&'
+// The comment is added to the last card, which may hold an empty string if the comment does not fit next to the string
+func longString(lhs string, q string, comment string) (string, error) {
+	var buf bytes.Buffer
+	prefix := lhs
+	tail := 0 // the room needed for the comment
+	if comment != "" {
+		tail = 3 + len(comment)
+	}
+	for {
+		room := 80 - len(prefix) - 2 // the room for the string between the quotes
+		if len(q)+tail <= room {
+			break
+		}
+		if room < 3 || len(q) == 0 {
+			return "", fmt.Errorf("Key or comment is too long to fit in a card: %v", strings.TrimSpace(lhs))
+		}
+		n := room - 1 // leaves room for '&'
+		if n > len(q) {
+			n = len(q)
+		}
+		if k := n - len(strings.TrimRight(q[:n], "'")); k%2 == 1 {
+			n-- // does not split a doubled quote
+		}
+		buf.WriteString(fmt.Sprintf("%-80s", prefix+"'"+q[:n]+"&'"))
+		q = q[n:]
+		prefix = "CONTINUE  "
+	}
+	last := prefix + "'" + q + "'"
+	if comment != "" {
+		last += " / " + comment
+	}
+	buf.WriteString(fmt.Sprintf("%-80s", last))
+	return buf.String(), nil
+}
+
+// formatFloat formats x such that NewHeader reads it back as a float64 (i.e. it always contains a '.' or an 'E')
+// NaN and Inf cannot be represented in a FITS header (the special values read from a file are written back as is, see specialToken)
+func formatFloat(x float64) (string, error) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return "", fmt.Errorf("%v cannot be written in a header", x)
+	}
+	s := strconv.FormatFloat(x, 'G', -1, 64)
+	if !strings.ContainsAny(s, ".E") {
+		s += ".0"
+	}
+	return s, nil
+}
+
+// rawData returns the data of h encoded as big-endian binary as it is stored in a FITS file (without padding)
+// For tables, Data is already a []byte and is returned as is, followed by the heap of binary tables with variable length arrays
+func (h *Unit) rawData() []byte {
+	if data, ok := h.Data.([]byte); ok {
+		if len(h.heap) > 0 {
+			return append(data[:len(data):len(data)], h.heap...)
+		}
+		return data
+	}
+	p, _ := ioutil.ReadAll(h.DataReader())
+	return p
+}
+
+// DataReader returns an io.Reader that yields the data of h as it is stored in a FITS file (big-endian binary without padding)
+// For tables, it reads directly from Data (and the heap of binary tables). For images, the pixels are encoded on the fly based
+// on the type of Data. The total number of bytes is equal to the number of pixels times |BITPIX|/8
+func (h *Unit) DataReader() io.Reader {
+	if data, ok := h.Data.([]byte); ok {
+		return io.MultiReader(bytes.NewReader(data), bytes.NewReader(h.heap))
+	}
+	r := &dataReader{data: h.Data}
+	switch x := h.Data.(type) {
+	case []int16:
+		r.size, r.n = 2, len(x)
+	case []int32:
+		r.size, r.n = 4, len(x)
+	case []float32:
+		r.size, r.n = 4, len(x)
+	case []int64:
+		r.size, r.n = 8, len(x)
+	case []float64:
+		r.size, r.n = 8, len(x)
+	}
+	return r
+}
+
+// DataHash feeds the data of h as it is stored in a FITS file (see DataReader) to hash and returns the resulting digest, e.g.
+//
+//	sum, err := h.DataHash(sha256.New())
+//
+// The padding and the header are not included, so identical data units have the same digest regardless of their headers
+// An error is returned if the data is not loaded (e.g. for units read by OpenHeaders)
+func (h *Unit) DataHash(hash hash.Hash) ([]byte, error) {
+	if h.Data == nil {
+		return nil, fmt.Errorf("Data is not loaded")
+	}
+	hash.Reset()
+	if _, err := io.Copy(hash, h.DataReader()); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// dataReader implements the io.Reader returned by DataReader for image data
+type dataReader struct {
+	data interface{} // The image data (Unit.Data)
+	size int         // The size of each pixel in bytes
+	n    int         // The number of pixels
+	pos  int         // The index of the next byte to read
+	elem [8]byte     // The encoded current pixel
+}
+
+func (r *dataReader) Read(p []byte) (n int, err error) {
+	total := r.n * r.size
+	if r.pos >= total {
+		return 0, io.EOF
+	}
+	for n < len(p) && r.pos < total {
+		i := r.pos / r.size
+		switch x := r.data.(type) {
+		case []int16:
+			binary.BigEndian.PutUint16(r.elem[:], uint16(x[i]))
+		case []int32:
+			binary.BigEndian.PutUint32(r.elem[:], uint32(x[i]))
+		case []float32:
+			binary.BigEndian.PutUint32(r.elem[:], math.Float32bits(x[i]))
+		case []int64:
+			binary.BigEndian.PutUint64(r.elem[:], uint64(x[i]))
+		case []float64:
+			binary.BigEndian.PutUint64(r.elem[:], math.Float64bits(x[i]))
+		}
+		k := copy(p[n:], r.elem[r.pos%r.size:r.size]) // a pixel may be split between two calls
+		n += k
+		r.pos += k
+	}
+	return n, nil
+}
+
+// paddedData returns the result of rawData padded to a multiple of 2880 bytes
+// The standard requires the data of ASCII tables to be padded with spaces and everything else with zeros
+func (h *Unit) paddedData() []byte {
+	data := h.rawData()
+	n := (len(data) + 2879) / 2880 * 2880
+	p := make([]byte, n)
+	copy(p, data)
+	if xten, _ := h.Keys["XTENSION"].(string); xten == "TABLE" {
+		for i := len(data); i < n; i++ {
+			p[i] = ' '
+		}
+	}
+	return p
+}
+
+// Writer is the counterpart of Reader for writing: it wraps an io.Writer and writes whole 2880-byte blocks to it
+// The values are encoded as big-endian binary as stored in FITS files. A value may straddle two blocks, in which case
+// it is split between them. Pad (or Flush) completes the current block, e.g. at the end of a header or a data section
+// The first error returned by the underlying writer is kept and returned by all the following calls
+type Writer struct {
+	buf    []byte
+	n      int // The number of bytes in buf
+	writer io.Writer
+	elem   [8]byte
+	err    error
+}
+
+// NewWriter generates a new fits.Writer that wraps the given writer
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{buf: make([]byte, 2880), writer: writer}
+}
+
+// Write appends p to the current block, writing each block to the underlying writer once it is full
+func (b *Writer) Write(p []byte) (n int, err error) {
+	for b.err == nil && n < len(p) {
+		k := copy(b.buf[b.n:], p[n:])
+		n += k
+		b.n += k
+		if b.n == len(b.buf) {
+			_, b.err = b.writer.Write(b.buf)
+			b.n = 0
+		}
+	}
+	return n, b.err
+}
+
+// WriteByte writes a single byte
+func (b *Writer) WriteByte(c byte) error {
+	b.elem[0] = c
+	_, err := b.Write(b.elem[:1])
+	return err
+}
+
+// WriteInt16 writes x as a 2-byte big-endian integer
+func (b *Writer) WriteInt16(x int16) error {
+	binary.BigEndian.PutUint16(b.elem[:], uint16(x))
+	_, err := b.Write(b.elem[:2])
+	return err
+}
+
+// WriteInt32 writes x as a 4-byte big-endian integer
+func (b *Writer) WriteInt32(x int32) error {
+	binary.BigEndian.PutUint32(b.elem[:], uint32(x))
+	_, err := b.Write(b.elem[:4])
+	return err
+}
+
+// WriteInt64 writes x as an 8-byte big-endian integer
+func (b *Writer) WriteInt64(x int64) error {
+	binary.BigEndian.PutUint64(b.elem[:], uint64(x))
+	_, err := b.Write(b.elem[:8])
+	return err
+}
+
+// WriteFloat32 writes x as a 4-byte big-endian IEEE 754 number
+func (b *Writer) WriteFloat32(x float32) error {
+	binary.BigEndian.PutUint32(b.elem[:], math.Float32bits(x))
+	_, err := b.Write(b.elem[:4])
+	return err
+}
+
+// WriteFloat64 writes x as an 8-byte big-endian IEEE 754 number
+func (b *Writer) WriteFloat64(x float64) error {
+	binary.BigEndian.PutUint64(b.elem[:], math.Float64bits(x))
+	_, err := b.Write(b.elem[:8])
+	return err
+}
+
+// WriteString writes the bytes of s as is (e.g. a header card or a string field)
+func (b *Writer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// Pad fills the rest of the current block with c and writes it, so the next value starts a new block
+// The standard requires spaces for headers and the data of ASCII tables and zeros for everything else
+// Pad does nothing if the current block is empty
+func (b *Writer) Pad(c byte) error {
+	if b.n == 0 || b.err != nil {
+		return b.err
+	}
+	for i := b.n; i < len(b.buf); i++ {
+		b.buf[i] = c
+	}
+	_, b.err = b.writer.Write(b.buf)
+	b.n = 0
+	return b.err
+}
+
+// Flush pads the current block with zeros (see Pad) and writes it
+func (b *Writer) Flush() error {
+	return b.Pad(0)
+}
+
+// ImageWriter writes a primary HDU holding an image pixel by pixel, so the pixels can be generated on the fly without holding
+// the whole image in memory, e.g.
+//
+//	iw, err := fits.NewImageWriter(w, -32, []int{nx, ny})
+//	for y := 0; y < ny; y++ {
+//		for x := 0; x < nx; x++ {
+//			iw.WritePixel(f(x, y))
+//		}
+//	}
+//	err = iw.Close()
+//
+// The pixels are written in the FITS order (the first axis varies fastest)
+type ImageWriter struct {
+	w      *Writer
+	bitpix int
+	total  int // The number of pixels, i.e. the product of NAXISn
+	count  int // The number of pixels written so far
+	closed bool
+}
+
+// NewImageWriter writes the header of an image with the given BITPIX and dimensions (naxis[k] is NAXIS{k+1}) to w
+// and returns an ImageWriter to stream the pixels
+func NewImageWriter(w io.Writer, bitpix int, naxis []int) (*ImageWriter, error) {
+	if typeName(bitpix) == "" {
+		return nil, fmt.Errorf("Invalid BITPIX %d", bitpix)
+	}
+	h := &Unit{Keys: map[string]interface{}{"SIMPLE": true, "BITPIX": bitpix, "NAXIS": len(naxis)}}
+	for i, n := range naxis {
+		if n < 0 {
+			return nil, fmt.Errorf("Invalid NAXIS%d=%d", i+1, n)
+		}
+		h.Keys[Nth("NAXIS", i+1)] = n
+	}
+	header, err := h.encodeHeader()
+	if err != nil {
+		return nil, err
+	}
+	iw := &ImageWriter{w: NewWriter(w), bitpix: bitpix, total: product(naxis)}
+	if len(naxis) == 0 {
+		iw.total = 0
+	}
+	if _, err = iw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return iw, nil
+}
+
+// WritePixel encodes v based on BITPIX and writes it as the next pixel
+// For integral types, v is rounded to the nearest integer. An error is returned if all the pixels have already been written
+func (iw *ImageWriter) WritePixel(v float64) error {
+	if iw.closed {
+		return fmt.Errorf("ImageWriter is closed")
+	}
+	if iw.count >= iw.total {
+		return fmt.Errorf("Too many pixels, the image has %d", iw.total)
+	}
+	if iw.bitpix > 0 {
+		v = math.Floor(v + 0.5)
+	}
+	var err error
+	switch iw.bitpix {
+	case 8:
+		err = iw.w.WriteByte(byte(v))
+	case 16:
+		err = iw.w.WriteInt16(int16(v))
+	case 32:
+		err = iw.w.WriteInt32(int32(v))
+	case 64:
+		err = iw.w.WriteInt64(int64(v))
+	case -32:
+		err = iw.w.WriteFloat32(float32(v))
+	case -64:
+		err = iw.w.WriteFloat64(v)
+	}
+	if err != nil {
+		return err
+	}
+	iw.count++
+	return nil
+}
+
+// WriteRow writes the pixels in row by calling WritePixel for each one
+func (iw *ImageWriter) WriteRow(row []float64) error {
+	for _, v := range row {
+		if err := iw.WritePixel(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close pads the data with zeros to a multiple of 2880 bytes and flushes it to the underlying writer, which is not closed
+// An error is returned if the number of pixels written is not equal to the number of pixels in the image
+func (iw *ImageWriter) Close() error {
+	if iw.closed {
+		return fmt.Errorf("ImageWriter is already closed")
+	}
+	iw.closed = true
+	if iw.count != iw.total {
+		return fmt.Errorf("%d pixels written, but the image has %d", iw.count, iw.total)
+	}
+	return iw.w.Flush()
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Shahriar Iravanian (siravan@svtsim.com).  All rights reserved.
+// Use of this source code is governed by a MIT license that can be found in the LICENSE file.
+
+package fits
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSortedKeys(t *testing.T) {
+	fits := openBytes(t, concat(emptyPrimary(), header(card("XTENSION", "'IMAGE'"), card("BITPIX", "8"), card("NAXIS", "1"), card("NAXIS1", "2"),
+		card("PCOUNT", "0"), card("GCOUNT", "1"), card("OBJECT", "'M31'"), card("DATE", "'2014-01-01'")), pad([]byte{1, 2})))
+	delete(fits[1].Keys, "GCOUNT") // a mandatory key missing from Keys is skipped
+	want := []string{"XTENSION", "BITPIX", "NAXIS", "NAXIS1", "PCOUNT", "DATE", "OBJECT"}
+	if keys := fits[1].SortedKeys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+}
+
+func TestFormatCardLongString(t *testing.T) {
+	// a single quote at each position of a 200-character value; the doubled quote should never be split across two cards
+	for i := 0; i < 200; i++ {
+		value := strings.Repeat("x", i) + "'" + strings.Repeat("y", 199-i)
+		for _, key := range []string{"HIERARCH ESO OBS LONG", "LONG"} {
+			s, err := FormatCard(key, value, "comment")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s)%80 != 0 {
+				t.Fatalf("%s: the cards are not 80 bytes long", key)
+			}
+			fits := openBytes(t, header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"), s))
+			if x := fits[0].Keys[key]; x != value {
+				t.Fatalf("%s, quote at %d: got %q, want %q", key, i, x, value)
+			}
+		}
+	}
+}
+
+func TestWriteHistory(t *testing.T) {
+	h := newTable(t, 2)
+	h.History = []string{"created by the test", "second line"}
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Unit{h}); err != nil {
+		t.Fatal(err)
+	}
+	fits := openBytes(t, buf.Bytes())
+	if !reflect.DeepEqual(fits[1].History, h.History) {
+		t.Errorf("got %q, want %q", fits[1].History, h.History)
+	}
+}
+
+func TestWriteChecksum(t *testing.T) {
+	img := image16(t, []string{card("OBJECT", "'M31'")}, 1, 2, 3, 4)
+	tab := newTable(t, 3)
+	for row := 0; row < 3; row++ {
+		tab.SetCell("A", row, int32(row))
+		tab.SetCell("V", row, []float64{1.5, float64(row)})
+	}
+	for _, h := range []*Unit{img, tab} {
+		if err := h.UpdateChecksum(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Unit{img, tab}); err != nil {
+		t.Fatal(err)
+	}
+	fits := openBytes(t, buf.Bytes())
+	for i, h := range fits {
+		if status := h.VerifyChecksum(); !status.DataSumOK || !status.ChecksumOK {
+			t.Errorf("unit %d: got %+v", i, status)
+		}
+	}
+}
+
+func TestWriteDefaultsAndComments(t *testing.T) {
+	cards := []string{card("NAXIS1", "4"), card("NAXIS2", "1"), card("PCOUNT", "0"), card("GCOUNT", "1"), card("TFIELDS", "1"), card("TFORM1", "'J'"),
+		rawCard("COMMENT   first comment"), rawCard("COMMENT second comment")}
+	fits := openBytes(t, binTable(cards, []byte{0, 0, 0, 7}))
+	if fits[1].Keys["TTYPE1"] != "COL1" {
+		t.Fatalf("got TTYPE1 = %v, want COL1", fits[1].Keys["TTYPE1"])
+	}
+	want := []string{"  first comment", "second comment"}
+	if !reflect.DeepEqual(fits[1].Comments, want) {
+		t.Errorf("got %q, want %q", fits[1].Comments, want)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, fits); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"TTYPE1", "TDISP1"} {
+		if bytes.Contains(buf.Bytes(), []byte(key)) {
+			t.Errorf("the default %v is written", key)
+		}
+	}
+	again := openBytes(t, buf.Bytes())
+	if !reflect.DeepEqual(again[1].Comments, want) {
+		t.Errorf("got %q after Write, want %q", again[1].Comments, want)
+	}
+	if x := again[1].Field(0)(0); x != int32(7) {
+		t.Errorf("got %v, want 7", x)
+	}
+}